@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/rvolosatovs/influx-taggify/taggify"
+)
+
+// commands are the subcommands, which are run instead of the conversion if they are the first argument.
+var commands = map[string]func(args []string){
+	"inspect": runInspect,
+	"suggest": runSuggest,
+	"verify":  runVerify,
+	"diff":    runDiff,
+}
+
+// newFlagSet returns a flag.FlagSet of command name, which takes arguments described by args.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] %s\n", os.Args[0], name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// createOutput creates the file at path, or returns stdout if path is '-' or empty.
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
+
+func runInspect(args []string) {
+	fs := newFlagSet("inspect", "")
+	from := fs.String("from", "", "export or line protocol to inspect, '-' for stdin (may be gzip-compressed)")
+	format := fs.String("format", "text", "format of the schema, either 'text' or 'json'")
+	maxValues := fs.Int("max-values", taggify.DefaultMaxValues, "number of distinct values tracked per tag key, string field and series of a measurement")
+	fs.Parse(args)
+
+	if *from == "" {
+		log.Fatal("-from flag must be specified")
+	}
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Unknown format '%s', must be either 'text' or 'json'", *format)
+	}
+	in, closeIn, err := openInput(*from)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %s", *from, err)
+	}
+	defer closeIn()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	s, err := taggify.Inspect(ctx, in, taggify.InspectConfig{MaxValues: *maxValues})
+	if err != nil {
+		log.Fatalf("Failed to inspect %s: %s", *from, err)
+	}
+	if err := taggify.WriteSchema(os.Stdout, s, *format); err != nil {
+		log.Fatalf("Failed to write schema: %s", err)
+	}
+}
+
+func runSuggest(args []string) {
+	fs := newFlagSet("suggest", "")
+	from := fs.String("from", "", "export or line protocol to rank the fields of, '-' for stdin (may be gzip-compressed)")
+	format := fs.String("format", "text", "format of the suggestions, either 'text' or 'json'")
+	maxValues := fs.Int("max-values", taggify.DefaultMaxValues, "number of distinct values and series tracked per field and measurement")
+	top := fs.Int("top", 10, "number of suggestions to print, all if not positive")
+	fs.Parse(args)
+
+	if *from == "" {
+		log.Fatal("-from flag must be specified")
+	}
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Unknown format '%s', must be either 'text' or 'json'", *format)
+	}
+	in, closeIn, err := openInput(*from)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %s", *from, err)
+	}
+	defer closeIn()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ss, err := taggify.Suggest(ctx, in, taggify.SuggestConfig{MaxValues: *maxValues, Top: *top})
+	if err != nil {
+		log.Fatalf("Failed to rank fields of %s: %s", *from, err)
+	}
+	if err := taggify.WriteSuggestions(os.Stdout, ss, *format); err != nil {
+		log.Fatalf("Failed to write suggestions: %s", err)
+	}
+}
+
+func runVerify(args []string) {
+	fs := newFlagSet("verify", "field...")
+	original := fs.String("original", "", "original export or line protocol, '-' for stdin (may be gzip-compressed)")
+	converted := fs.String("converted", "", "converted export or line protocol, '-' for stdin (may be gzip-compressed)")
+	var transformers transformersFlag
+	fs.Var(&transformers, "transform", "transformer applied to each grouped row during the conversion, as 'name[:arg]', may be repeated")
+	format := fs.String("format", "text", "format of the report, either 'text' or 'json'")
+	maxExamples := fs.Int("max-examples", taggify.DefaultMaxExamples, "number of examples reported for each kind of discrepancy")
+	fs.Parse(args)
+
+	if *original == "" || *converted == "" {
+		log.Fatal("-original and -converted flags must be specified")
+	}
+	if *original == "-" && *converted == "-" {
+		log.Fatal("Only one of -original and -converted may be read from stdin")
+	}
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Unknown format '%s', must be either 'text' or 'json'", *format)
+	}
+	orig, closeOrig, err := openInput(*original)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %s", *original, err)
+	}
+	defer closeOrig()
+	conv, closeConv, err := openInput(*converted)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %s", *converted, err)
+	}
+	defer closeConv()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	v, err := taggify.Verify(ctx, orig, conv, taggify.VerifyConfig{
+		Fields:       fs.Args(),
+		Transformers: transformers,
+		MaxExamples:  *maxExamples,
+	})
+	if err != nil {
+		log.Fatalf("Failed to verify %s: %s", *converted, err)
+	}
+	if err := taggify.WriteVerification(os.Stdout, v, *format); err != nil {
+		log.Fatalf("Failed to write report: %s", err)
+	}
+	if !v.OK() {
+		log.Fatalf("Verification failed: %d missing, %d extra and %d altered field values", v.Missing, v.Extra, v.Altered)
+	}
+}
+
+func runDiff(args []string) {
+	fs := newFlagSet("diff", "first second")
+	chunkSize := fs.Int("chunk-size", taggify.DefaultDiffChunkSize, "number of points of each input sorted in memory, larger inputs are sorted using temporary files")
+	tempDir := fs.String("temp-dir", "", "directory to create temporary files in, the default directory for temporary files if empty")
+	maxDifferences := fs.Int("max-differences", 0, "number of differences listed, all if not positive")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	first, second := fs.Arg(0), fs.Arg(1)
+	if first == "-" && second == "-" {
+		log.Fatal("Only one of the inputs may be read from stdin")
+	}
+	a, closeA, err := openInput(first)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %s", first, err)
+	}
+	defer closeA()
+	b, closeB, err := openInput(second)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %s", second, err)
+	}
+	defer closeB()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	st, err := taggify.Diff(ctx, a, b, os.Stdout, taggify.DiffConfig{
+		ChunkSize:      *chunkSize,
+		TempDir:        *tempDir,
+		MaxDifferences: *maxDifferences,
+	})
+	if err != nil {
+		log.Fatalf("Failed to compare %s and %s: %s", first, second, err)
+	}
+	fmt.Fprintln(os.Stderr, st)
+	if !st.Identical() {
+		os.Exit(1)
+	}
+}
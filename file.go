@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/influx-taggify/taggify"
+)
+
+// atomicFile is a temporary file, which atomically replaces the file at path on commit.
+type atomicFile struct {
+	*os.File
+	path string
+	// done is set once the file is committed or aborted.
+	done bool
+}
+
+// createAtomic creates a temporary file in the directory of path.
+// If a file exists at path, its permissions are copied to the temporary file.
+func createAtomic(path string) (*atomicFile, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary file")
+	}
+	if fi, err := os.Stat(path); err == nil {
+		if err := f.Chmod(fi.Mode().Perm()); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, errors.Wrap(err, "failed to set permissions of temporary file")
+		}
+	}
+	return &atomicFile{File: f, path: path}, nil
+}
+
+// copyFile copies the file at src to dst, creating or truncating dst.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// backup preserves the file at path as path.bak, if it exists.
+func backup(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	bak := path + ".bak"
+	if err := os.Remove(bak); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(path, bak); err == nil {
+		return nil
+	}
+	return copyFile(bak, path)
+}
+
+// syncDir flushes the directory entry changes of dir to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// commit flushes f to disk and renames it to f.path.
+// If keepBackup is set, the file previously at f.path is preserved as f.path.bak.
+func (f *atomicFile) commit(keepBackup bool) error {
+	if err := f.Sync(); err != nil {
+		f.abort()
+		return errors.Wrap(err, "failed to sync temporary file")
+	}
+	f.done = true
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "failed to close temporary file")
+	}
+	if keepBackup {
+		if err := backup(f.path); err != nil {
+			os.Remove(f.Name())
+			return errors.Wrapf(err, "failed to create backup of %s", f.path)
+		}
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "failed to rename temporary file to %s", f.path)
+	}
+	return syncDir(filepath.Dir(f.path))
+}
+
+// abort closes and removes f, unless it is already committed or aborted.
+func (f *atomicFile) abort() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompress returns a reader of decompressed contents of r if r is gzip-compressed, and r otherwise.
+// The returned bool reports whether r is gzip-compressed.
+func decompress(r io.Reader) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	b, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, false, errors.Wrap(err, "failed to read input")
+	}
+	if !bytes.Equal(b, gzipMagic) {
+		return br, false, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to decompress input")
+	}
+	return zr, true, nil
+}
+
+// openInput opens the file at path, or stdin if path is '-', decompressing it if needed.
+// The returned function closes the file.
+func openInput(path string) (io.Reader, func() error, error) {
+	var f *os.File
+	if path == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	r, _, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return r, f.Close, nil
+}
+
+// readCheckpoint reads the checkpoint at path. If no file exists at path, an empty checkpoint is returned.
+func readCheckpoint(path string) (*taggify.Checkpoint, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &taggify.Checkpoint{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read checkpoint")
+	}
+	cp := &taggify.Checkpoint{}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, errors.Wrap(err, "failed to decode checkpoint")
+	}
+	return cp, nil
+}
+
+// writeCheckpoint atomically replaces the checkpoint at path by cp.
+func writeCheckpoint(path string, cp taggify.Checkpoint) error {
+	f, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(cp); err != nil {
+		f.abort()
+		return errors.Wrap(err, "failed to encode checkpoint")
+	}
+	return f.commit(false)
+}
+
+// fingerprintSize is the number of leading and trailing bytes of a file hashed by fingerprintFile.
+const fingerprintSize = 1 << 20
+
+// fingerprintFile identifies the file at path by its size, modification time
+// and a hash of its first and last fingerprintSize bytes.
+func fingerprintFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, fingerprintSize); err != nil && err != io.EOF {
+		return "", err
+	}
+	if fi.Size() > fingerprintSize {
+		if _, err := f.Seek(-fingerprintSize, io.SeekEnd); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("size=%d mtime=%s sha256=%x", fi.Size(), fi.ModTime().UTC().Format(time.RFC3339Nano), h.Sum(nil)), nil
+}
+
+// fingerprintDirs identifies the files in dirs by their paths, sizes and modification times.
+// Empty dirs are ignored.
+func fingerprintDirs(dirs ...string) (string, error) {
+	h := sha256.New()
+	n := 0
+	for i, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.Mode().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			n++
+			fmt.Fprintf(h, "%d %s %d %d\n", i, filepath.ToSlash(rel), fi.Size(), fi.ModTime().UnixNano())
+			return nil
+		}); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("files=%d sha256=%x", n, h.Sum(nil)), nil
+}
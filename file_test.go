@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAtomicFile(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "taggify")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "export")
+	a.NoError(ioutil.WriteFile(path, []byte("original"), 0640))
+
+	f, err := createAtomic(path)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	_, err = f.WriteString("aborted")
+	a.NoError(err)
+	a.NoError(f.abort())
+
+	f, err = createAtomic(path)
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	_, err = f.WriteString("converted")
+	a.NoError(err)
+
+	b, err := ioutil.ReadFile(path)
+	a.NoError(err)
+	a.Equal("original", string(b), "file replaced before commit")
+
+	a.NoError(f.commit(true))
+	a.NoError(f.abort(), "deferred abort after commit")
+
+	b, err = ioutil.ReadFile(path)
+	a.NoError(err)
+	a.Equal("converted", string(b))
+
+	b, err = ioutil.ReadFile(path + ".bak")
+	a.NoError(err)
+	a.Equal("original", string(b))
+
+	fi, err := os.Stat(path)
+	a.NoError(err)
+	a.Equal(os.FileMode(0640), fi.Mode().Perm())
+
+	fis, err := ioutil.ReadDir(dir)
+	a.NoError(err)
+	a.Len(fis, 2, "temporary files left behind")
+}
+
+func TestDecompress(t *testing.T) {
+	a := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	_, err := zw.Write([]byte("compressed"))
+	a.NoError(err)
+	a.NoError(zw.Close())
+
+	for _, tc := range []struct {
+		in       []byte
+		gzipped  bool
+		expected string
+	}{
+		{buf.Bytes(), true, "compressed"},
+		{[]byte("plain"), false, "plain"},
+		{[]byte("p"), false, "p"},
+		{nil, false, ""},
+	} {
+		r, gzipped, err := decompress(bytes.NewReader(tc.in))
+		if !a.NoError(err) {
+			continue
+		}
+		a.Equal(tc.gzipped, gzipped)
+		b, err := ioutil.ReadAll(r)
+		a.NoError(err)
+		a.Equal(tc.expected, string(b))
+	}
+}
+
+func TestFingerprintFile(t *testing.T) {
+	a := assert.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "export")
+	mtime := time.Unix(1600000000, 0)
+	write := func(b []byte) string {
+		a.NoError(ioutil.WriteFile(path, b, 0644))
+		a.NoError(os.Chtimes(path, mtime, mtime))
+		id, err := fingerprintFile(path)
+		a.NoError(err)
+		return id
+	}
+
+	large := bytes.Repeat([]byte("a"), 3*fingerprintSize)
+	id := write(large)
+	a.Equal(id, write(large))
+
+	large[len(large)-1] = 'b'
+	a.NotEqual(id, write(large), "change at the end of the file not detected")
+
+	// changes in the middle of large files are only detected by the modification time
+	large[len(large)-1] = 'a'
+	large[len(large)/2] = 'b'
+	a.Equal(id, write(large))
+
+	a.NotEqual(id, write(large[:len(large)-1]))
+
+	_, err := fingerprintFile(filepath.Join(dir, "missing"))
+	a.Error(err)
+}
@@ -3,7 +3,9 @@ module github.com/rvolosatovs/influx-taggify
 go 1.16
 
 require (
+	github.com/golang/snappy v0.0.1
 	github.com/influxdata/influxdb v1.9.1
+	github.com/influxdata/influxql v1.1.1-0.20210223160523-b6ab99450c93
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.7.0
 )
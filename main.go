@@ -0,0 +1,513 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rvolosatovs/influx-taggify/taggify"
+)
+
+// transformersFlag is a flag.Value, which constructs a taggify.PointTransformer for each occurrence of the flag.
+type transformersFlag []taggify.PointTransformer
+
+func (f *transformersFlag) String() string {
+	return ""
+}
+
+func (f *transformersFlag) Set(s string) error {
+	t, err := taggify.NewTransformer(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, t)
+	return nil
+}
+
+// bucketsFlag is a flag.Value, which collects 'db/rp=bucket' mappings.
+type bucketsFlag map[string]string
+
+func (f bucketsFlag) String() string {
+	return ""
+}
+
+func (f bucketsFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 || i == len(s)-1 {
+		return errors.Errorf("invalid mapping '%s', must be of form 'db/rp=bucket' or 'db=bucket'", s)
+	}
+	f[s[:i]] = s[i+1:]
+	return nil
+}
+
+// durationsFlag is a flag.Value, which collects 'db/rp=duration' mappings.
+type durationsFlag map[string]time.Duration
+
+func (f durationsFlag) String() string {
+	return ""
+}
+
+func (f durationsFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 || i == len(s)-1 {
+		return errors.Errorf("invalid mapping '%s', must be of form 'db/rp=duration' or 'db=duration'", s)
+	}
+	d, err := time.ParseDuration(s[i+1:])
+	if err != nil {
+		return err
+	}
+	f[s[:i]] = d
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
+
+	from := flag.String("from", "", "file containing data in line-protocol format, '-' for stdin (may be gzip-compressed)")
+	to := flag.String("to", "", "file to output the result to, '-' for stdout (defaults to stdout if not specified), compressed with gzip if it has .gz extension")
+	compress := flag.Bool("compress", false, "compress the output with gzip")
+	maxSeries := flag.Int("max-series", 0, "abort if the result would contain more than this many series (0 means no limit)")
+	dryRun := flag.Bool("dry-run", false, "parse and group the data without writing any output, print the report to stdout instead")
+	reportFormat := flag.String("report", "text", "format of the report, either 'text' or 'json'")
+	showProgress := flag.Bool("progress", true, "periodically report progress to stderr")
+	progressInterval := flag.Duration("progress-interval", 10*time.Second, "interval between progress reports")
+	keepBackup := flag.Bool("backup", false, "keep the file previously at -to as <file>.bak")
+	force := flag.Bool("force", false, "overwrite the file at -to if it exists")
+	maxLineLength := flag.Int("max-line-length", 0, "maximum length of a line in bytes (0 means no limit)")
+	rejectsPath := flag.String("rejects", "", "file to write lines, which fail to parse, to")
+	var onError taggify.ErrorPolicy
+	flag.Var(&onError, "on-error", "how to handle lines, which fail to parse, one of 'abort', 'skip' or 'max-errors=N'")
+	var transformers transformersFlag
+	flag.Var(&transformers, "transform", "transformer to apply to each grouped row, as 'name[:arg]', may be repeated (available: "+strings.Join(taggify.RegisteredTransformers(), ", ")+")")
+	execCmd := flag.String("exec", "", "command to pass batches of grouped rows through as line protocol via stdin/stdout, arguments are separated by whitespace")
+	execBatchSize := flag.Int("exec-batch-size", taggify.DefaultBatchSize, "number of points passed to the -exec command at once")
+	execTimeout := flag.Duration("exec-timeout", time.Minute, "maximum duration the -exec command may take to return a single batch (0 means no limit)")
+	influxURL := flag.String("influx-url", "", "base URL of InfluxDB to write the result to directly instead of -to, e.g. http://localhost:8086")
+	influxDB := flag.String("influx-db", "", "database to write to, overrides the database from the export")
+	influxRP := flag.String("influx-rp", "", "retention policy to write to, overrides the retention policy from the export")
+	influxUsername := flag.String("influx-username", "", "username to authenticate with")
+	influxPassword := flag.String("influx-password", os.Getenv("INFLUX_PASSWORD"), "password to authenticate with (defaults to $INFLUX_PASSWORD)")
+	influxOrg := flag.String("influx-org", "", "organization to write to in InfluxDB 2.x, enables writes to the v2 API")
+	influxBucket := flag.String("influx-bucket", "", "bucket to write all data to in InfluxDB 2.x")
+	influxToken := flag.String("influx-token", os.Getenv("INFLUX_TOKEN"), "API token for InfluxDB 2.x (defaults to $INFLUX_TOKEN)")
+	influxBuckets := bucketsFlag{}
+	flag.Var(influxBuckets, "influx-bucket-map", "mapping of database and retention policy to InfluxDB 2.x bucket as 'db/rp=bucket' or 'db=bucket', may be repeated")
+	influxRetries := flag.Int("influx-retries", taggify.DefaultHTTPRetries, "number of times a failed write request is retried (negative value disables retries)")
+	influxRetryInterval := flag.Duration("influx-retry-interval", taggify.DefaultHTTPRetryInterval, "delay before the first retry of a failed write request, doubled with each retry")
+	influxPrecision := flag.String("influx-precision", "ns", "precision of the written timestamps, one of 'ns', 'u', 'ms', 's', 'm' or 'h'")
+	influxBatchSize := flag.Int("influx-batch-size", taggify.DefaultHTTPBatchSize, "number of points written in a single request")
+	influxConcurrency := flag.Int("influx-concurrency", taggify.DefaultHTTPConcurrency, "maximum number of concurrent write requests")
+	influxGzip := flag.Bool("influx-gzip", false, "compress write requests with gzip")
+	checkpointPath := flag.String("checkpoint", "", "file to save the progress of writes to InfluxDB to, an interrupted conversion is resumed from it if it exists")
+	checkpointInterval := flag.Int("checkpoint-interval", taggify.DefaultCheckpointInterval, "number of points written to InfluxDB between checkpoints")
+	deadLetterPath := flag.String("dead-letter", "", "file to write batches rejected by InfluxDB as invalid to, instead of aborting the conversion")
+	execd := flag.Bool("execd", false, "run as a Telegraf execd processor, reading line protocol from stdin and writing the result to stdout until stdin is closed")
+	window := flag.Duration("window", taggify.DefaultWindow, "in -execd mode, period within which lines with equal series key and timestamp are merged into a single point")
+	dataDir := flag.String("datadir", "", "data directory of InfluxDB to read TSM files from instead of -from")
+	walDir := flag.String("waldir", "", "WAL directory of InfluxDB to read WAL files from instead of -from")
+	database := flag.String("database", "", "with -datadir, -waldir or -query-url, the only database to read")
+	retention := flag.String("retention", "", "with -datadir, -waldir or -query-url, the only retention policy to read, requires -database")
+	start := flag.String("start", "", "with -datadir, -waldir or -query-url, the earliest timestamp to read in RFC3339 format")
+	end := flag.String("end", "", "with -datadir, -waldir or -query-url, the latest timestamp to read in RFC3339 format")
+	queryURL := flag.String("query-url", "", "base URL of InfluxDB to read the data from through the InfluxQL query API instead of -from")
+	queryUsername := flag.String("query-username", "", "username to authenticate with at -query-url")
+	queryPassword := flag.String("query-password", os.Getenv("INFLUX_QUERY_PASSWORD"), "password to authenticate with at -query-url (defaults to $INFLUX_QUERY_PASSWORD)")
+	queryToken := flag.String("query-token", os.Getenv("INFLUX_QUERY_TOKEN"), "API token to authenticate with at the 1.x compatibility API of InfluxDB 2.x at -query-url (defaults to $INFLUX_QUERY_TOKEN)")
+	queryChunkSize := flag.Int("query-chunk-size", taggify.DefaultQueryChunkSize, "number of points InfluxDB returns in a single chunk of a query response")
+	tsmDir := flag.String("tsm-dir", "", "directory to write the result to as TSM files of shards instead of -to, laid out as the data directory of InfluxDB 1.x")
+	tsmShardDuration := flag.Duration("tsm-shard-duration", taggify.DefaultShardDuration, "duration of shard groups written to -tsm-dir, must match the shard group duration of the retention policies")
+	tsmShardDurations := durationsFlag{}
+	flag.Var(tsmShardDurations, "tsm-shard-duration-map", "duration of shard groups of a database and retention policy as 'db/rp=duration' or 'db=duration', may be repeated")
+	tsmFirstShardID := flag.Uint64("tsm-first-shard-id", 1, "ID of the first shard written to -tsm-dir")
+	tsmMaxBufferedValues := flag.Int("tsm-max-buffered-values", taggify.DefaultMaxBufferedValues, "number of field values buffered in memory before they are written to -tsm-dir")
+	flag.Parse()
+
+	if *execd {
+		runExecd(*window, taggify.Options{
+			Fields:        flag.Args(),
+			OnError:       onError,
+			MaxLineLength: *maxLineLength,
+			Transformers:  transformers,
+		}, *rejectsPath)
+		return
+	}
+
+	readFiles := *dataDir != "" || *walDir != ""
+	readQuery := *queryURL != ""
+	switch n := boolCount(*from != "", readFiles, readQuery); {
+	case n == 0:
+		log.Fatal("-from, -datadir or -query-url flag must be specified")
+	case n > 1:
+		log.Fatal("-from, -datadir/-waldir and -query-url are mutually exclusive")
+	}
+	startTime, endTime := parseTimeFlag("start", *start), parseTimeFlag("end", *end)
+	filesConf := taggify.FilesConfig{
+		DataDir:         *dataDir,
+		WALDir:          *walDir,
+		Database:        *database,
+		RetentionPolicy: *retention,
+		Start:           startTime,
+		End:             endTime,
+	}
+	queryConf := taggify.QueryConfig{
+		URL:             *queryURL,
+		Username:        *queryUsername,
+		Password:        *queryPassword,
+		Token:           *queryToken,
+		Database:        *database,
+		RetentionPolicy: *retention,
+		Start:           startTime,
+		End:             endTime,
+		ChunkSize:       *queryChunkSize,
+	}
+	if *reportFormat != "text" && *reportFormat != "json" {
+		log.Fatalf("Unknown report format '%s', must be either 'text' or 'json'", *reportFormat)
+	}
+
+	var writer taggify.Writer
+	var httpWriter *taggify.HTTPWriter
+	var tsmWriter *taggify.TSMWriter
+	var checkpoint *taggify.Checkpoint
+	var inputID string
+	if *influxURL != "" {
+		if *to != "" {
+			log.Fatal("-to and -influx-url are mutually exclusive")
+		}
+
+		var deadLetter io.Writer
+		if *deadLetterPath != "" {
+			f, err := os.OpenFile(*deadLetterPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				log.Fatalf("Failed to open dead-letter file at %s: %s", *deadLetterPath, err)
+			}
+			defer f.Close()
+			deadLetter = f
+		}
+		if *checkpointPath != "" {
+			var err error
+			checkpoint, err = readCheckpoint(*checkpointPath)
+			if err != nil {
+				log.Fatalf("Failed to load checkpoint from %s: %s", *checkpointPath, err)
+			}
+			if checkpoint.Lines > 0 {
+				log.Printf("Resuming from checkpoint at %s, skipping %d points", *checkpointPath, checkpoint.Lines)
+			}
+
+			switch {
+			case readFiles:
+				inputID, err = fingerprintDirs(*dataDir, *walDir)
+				inputID += fmt.Sprintf(" database=%s retention=%s start=%s end=%s", *database, *retention, *start, *end)
+			case readQuery:
+				// changes of the data at the server cannot be detected
+				inputID = fmt.Sprintf("url=%s database=%s retention=%s start=%s end=%s", *queryURL, *database, *retention, *start, *end)
+			case *from == "-":
+				log.Fatal("-checkpoint requires -from to be a file, stdin cannot be identified on resume")
+			default:
+				inputID, err = fingerprintFile(*from)
+			}
+			if err != nil {
+				log.Fatalf("Failed to identify input for checkpoint: %s", err)
+			}
+		}
+
+		var err error
+		httpWriter, err = taggify.NewHTTPWriter(taggify.HTTPConfig{
+			URL:             *influxURL,
+			Database:        *influxDB,
+			RetentionPolicy: *influxRP,
+			Username:        *influxUsername,
+			Password:        *influxPassword,
+			Org:             *influxOrg,
+			Bucket:          *influxBucket,
+			Buckets:         influxBuckets,
+			Token:           *influxToken,
+			Retries:         *influxRetries,
+			RetryInterval:   *influxRetryInterval,
+			Precision:       *influxPrecision,
+			BatchSize:       *influxBatchSize,
+			Concurrency:     *influxConcurrency,
+			Gzip:            *influxGzip,
+			DeadLetter:      deadLetter,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure InfluxDB output: %s", err)
+		}
+		writer = httpWriter
+	} else if *checkpointPath != "" || *deadLetterPath != "" {
+		log.Fatal("-checkpoint and -dead-letter require -influx-url")
+	}
+	if *tsmDir != "" {
+		if *to != "" || *influxURL != "" {
+			log.Fatal("-tsm-dir, -to and -influx-url are mutually exclusive")
+		}
+		var err error
+		tsmWriter, err = taggify.NewTSMWriter(taggify.TSMConfig{
+			Dir:            *tsmDir,
+			ShardDuration:  *tsmShardDuration,
+			ShardDurations: tsmShardDurations,
+			FirstShardID:   *tsmFirstShardID,
+
+			MaxBufferedValues: *tsmMaxBufferedValues,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure TSM output: %s", err)
+		}
+		writer = tsmWriter
+	}
+
+	var f *os.File
+	var size int64
+	if readFiles || readQuery {
+		// the input is read by taggify.TransformFiles or taggify.TransformQuery
+	} else if *from == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		f, err = os.OpenFile(*from, os.O_RDONLY, 0)
+		if err != nil {
+			log.Fatalf("Failed to open file for read at %s: %s", *from, err)
+		}
+		defer f.Close()
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			size = fi.Size()
+		}
+	}
+
+	var p *taggify.Progress
+	if *showProgress && *progressInterval > 0 {
+		p = &taggify.Progress{}
+		done := make(chan struct{})
+		defer close(done)
+		go p.Report(os.Stderr, size, *progressInterval, done)
+	}
+
+	var in io.Reader
+	var gzipped bool
+	var err error
+	if f != nil {
+		in, gzipped, err = decompress(taggify.ProgressReader{Reader: f, Progress: p})
+		if err != nil {
+			log.Fatalf("Failed to read %s: %s", *from, err)
+		}
+	}
+	inPlace := *to == *from && *to != "-" && *from != ""
+	compressOut := *compress || strings.HasSuffix(*to, ".gz") || inPlace && gzipped
+
+	var rejects io.Writer
+	if *rejectsPath != "" {
+		f, err := os.Create(*rejectsPath)
+		if err != nil {
+			log.Fatalf("Failed to create rejects file at %s: %s", *rejectsPath, err)
+		}
+		defer f.Close()
+		rejects = f
+	}
+
+	var out io.Writer = os.Stdout
+
+	// tmp is the temporary file, which replaces the file at -to on success.
+	// It is removed by each error path from here on, so log.Fatalf must not be called before it is committed or aborted.
+	var tmp *atomicFile
+
+	if *dryRun || writer != nil {
+		out = ioutil.Discard
+	} else if *to != "" && *to != "-" {
+		if !inPlace && !*force {
+			if _, err := os.Stat(*to); err == nil {
+				log.Fatalf("File %s already exists, specify -force to overwrite it", *to)
+			} else if !os.IsNotExist(err) {
+				log.Fatalf("Failed to stat %s: %s", *to, err)
+			}
+		}
+		tmp, err = createAtomic(*to)
+		if err != nil {
+			log.Fatalf("Failed to prepare output file at %s: %s", *to, err)
+		}
+		defer tmp.abort()
+		out = tmp
+	}
+
+	var zw *gzip.Writer
+	if compressOut && !*dryRun && writer == nil {
+		zw = gzip.NewWriter(out)
+		out = zw
+	}
+
+	var batch taggify.BatchTransformer
+	var execer *taggify.Exec
+	if args := strings.Fields(*execCmd); len(args) > 0 {
+		execer = &taggify.Exec{
+			Path:    args[0],
+			Args:    args[1:],
+			Timeout: *execTimeout,
+			Stderr:  os.Stderr,
+		}
+		batch = execer
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opts := taggify.Options{
+		Fields:        flag.Args(),
+		MaxSeries:     *maxSeries,
+		DryRun:        *dryRun,
+		OnError:       onError,
+		MaxLineLength: *maxLineLength,
+		Rejects:       rejects,
+		Progress:      p,
+		Transformers:  transformers,
+
+		BatchTransformer: batch,
+		BatchSize:        *execBatchSize,
+
+		Checkpoint:         checkpoint,
+		InputID:            inputID,
+		CheckpointInterval: *checkpointInterval,
+		OnCheckpoint: func(cp taggify.Checkpoint) error {
+			return writeCheckpoint(*checkpointPath, cp)
+		},
+	}
+	if writer != nil {
+		opts.Writer = writer
+	}
+	var st taggify.Stats
+	switch {
+	case readFiles:
+		st, err = taggify.TransformFiles(ctx, filesConf, out, opts)
+	case readQuery:
+		st, err = taggify.TransformQuery(ctx, queryConf, out, opts)
+	default:
+		st, err = taggify.Transform(ctx, in, out, opts)
+	}
+	if execer != nil {
+		if cerr := execer.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	var w io.Writer = os.Stderr
+	if *dryRun {
+		w = os.Stdout
+	}
+	if err := taggify.WriteReport(w, &st, *reportFormat); err != nil {
+		log.Printf("Failed to write report: %s", err)
+	}
+	if err == nil && st.ParseErrors > 0 && !*dryRun {
+		if *rejectsPath != "" {
+			log.Printf("Rejected %d lines, see %s", st.ParseErrors, *rejectsPath)
+		} else {
+			log.Printf("Rejected %d lines", st.ParseErrors)
+		}
+	}
+	if err == nil && zw != nil {
+		err = errors.Wrap(zw.Close(), "failed to compress output")
+	}
+	if err != nil {
+		if tmp != nil {
+			tmp.abort()
+		}
+		log.Fatalf("Failed to convert data: %s", err)
+	}
+	if tmp != nil {
+		if err := tmp.commit(*keepBackup); err != nil {
+			log.Fatalf("Failed to write %s: %s", *to, err)
+		}
+	}
+	if tsmWriter != nil && !*dryRun {
+		printTSMSteps(os.Stdout, *tsmDir, tsmWriter.Shards())
+	}
+	if httpWriter != nil {
+		if n := httpWriter.Rejected(); n > 0 {
+			log.Printf("InfluxDB rejected %d points, see %s", n, *deadLetterPath)
+		}
+		// the conversion is complete, so a subsequent run must not resume it
+		if *checkpointPath != "" && !*dryRun {
+			if err := os.Remove(*checkpointPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove checkpoint at %s: %s", *checkpointPath, err)
+			}
+		}
+	}
+}
+
+// runExecd runs the conversion as a Telegraf execd processor.
+func runExecd(window time.Duration, opts taggify.Options, rejectsPath string) {
+	if rejectsPath != "" {
+		f, err := os.Create(rejectsPath)
+		if err != nil {
+			log.Fatalf("Failed to create rejects file at %s: %s", rejectsPath, err)
+		}
+		defer f.Close()
+		opts.Rejects = f
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	st, err := taggify.Stream(ctx, os.Stdin, os.Stdout, window, opts)
+	if err != nil && err != context.Canceled {
+		log.Fatalf("Failed to convert data: %s", err)
+	}
+	if st.ParseErrors > 0 {
+		log.Printf("Rejected %d lines", st.ParseErrors)
+	}
+}
+
+// printTSMSteps prints the shards written to dir and the steps needed to load them into InfluxDB.
+func printTSMSteps(w io.Writer, dir string, shards []taggify.Shard) {
+	fmt.Fprintf(w, "Wrote %d shards to %s:\n", len(shards), dir)
+	for _, s := range shards {
+		fmt.Fprintf(w, "  %s/%s/%d: %s - %s, %d values in %d files\n",
+			s.Database, s.RetentionPolicy, s.ID, s.Start.Format(time.RFC3339), s.End.Format(time.RFC3339), s.Values, s.Files)
+	}
+	fmt.Fprint(w, `
+To load the shards into InfluxDB 1.x with data directory $DATADIR and WAL directory $WALDIR:
+1. Create the databases and retention policies, if they do not exist. The shard group durations must match the ones used here.
+2. Create the shard group of each shard by writing a single point within its time range and find its ID with SHOW SHARDS.
+3. Stop influxd.
+4. Replace $DATADIR/<db>/<rp>/<ID> with the shard directory written here and remove $WALDIR/<db>/<rp>/<ID>.
+5. Build the index of each replaced shard as the user running influxd:
+`)
+	for _, s := range shards {
+		fmt.Fprintf(w, "   influx_inspect buildtsi -datadir \"$DATADIR\" -waldir \"$WALDIR\" -database %s -retention %s -shard <ID>  # shard %d written here\n",
+			s.Database, s.RetentionPolicy, s.ID)
+	}
+	fmt.Fprintln(w, "6. Start influxd.")
+}
+
+// parseTimeFlag parses the value of flag name in RFC3339 format, an empty value is parsed as zero time.
+func parseTimeFlag(name, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		log.Fatalf("Failed to parse -%s: %s", name, err)
+	}
+	return t
+}
+
+// boolCount returns the number of bs, which are true.
+func boolCount(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,113 @@
+package taggify
+
+import (
+	"bufio"
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultBatchSize is the default number of points passed to a BatchTransformer at once.
+const DefaultBatchSize = 1000
+
+// BatchTransformer transforms batches of grouped rows before they are written.
+type BatchTransformer interface {
+	// TransformBatch returns the points to write in place of points, in order.
+	TransformBatch(ctx context.Context, points []*Point) ([]*Point, error)
+}
+
+// emitter writes points to w, passing them through batch first, if it is not nil.
+type emitter struct {
+	w         *bufio.Writer
+	batch     BatchTransformer
+	batchSize int
+	progress  *Progress
+	// wholeLines, if set, makes each point be written on a single line.
+	wholeLines bool
+	// dest, if not nil, is where points are written to instead of w, using database db and retention policy rp.
+	dest   Writer
+	db, rp string
+
+	pending []*Point
+	line    []byte
+	// written is the number of lines written.
+	written int
+}
+
+// emit writes points or queues them for the batch transformer.
+func (e *emitter) emit(ctx context.Context, points ...*Point) error {
+	if e.batch == nil {
+		for _, p := range points {
+			if err := e.write(ctx, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	e.pending = append(e.pending, points...)
+	size := e.batchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if len(e.pending) < size {
+		return nil
+	}
+	return e.flush(ctx)
+}
+
+// flush passes the queued points through the batch transformer and writes the result.
+func (e *emitter) flush(ctx context.Context) error {
+	if len(e.pending) == 0 {
+		return nil
+	}
+	points, err := e.batch.TransformBatch(ctx, e.pending)
+	if err != nil {
+		return errors.Wrap(err, "batch transformer failed")
+	}
+	e.pending = e.pending[:0]
+	for _, p := range points {
+		if err := e.write(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes p with each field on a separate line, as influx_inspect does, unless e.wholeLines is set
+// or e.dest is not nil.
+func (e *emitter) write(ctx context.Context, p *Point) error {
+	if e.dest != nil {
+		e.line = p.AppendLine(e.line[:0])
+		if err := e.dest.WriteLine(ctx, e.db, e.rp, e.line); err != nil {
+			return err
+		}
+		e.progress.addPoint()
+		e.written++
+		return nil
+	}
+	if e.wholeLines {
+		e.line = append(p.AppendLine(e.line[:0]), '\n')
+		if _, err := e.w.Write(e.line); err != nil {
+			return errors.Wrap(err, "failed to write data")
+		}
+		e.progress.addPoint()
+		e.written++
+		return nil
+	}
+	key := p.SeriesKey()
+	for _, f := range p.Fields {
+		e.line = append(e.line[:0], key...)
+		e.line = append(e.line, ' ')
+		e.line = appendFields(e.line, []Field{f})
+		e.line = append(e.line, ' ')
+		e.line = strconv.AppendInt(e.line, p.Time, 10)
+		e.line = append(e.line, '\n')
+		if _, err := e.w.Write(e.line); err != nil {
+			return errors.Wrap(err, "failed to write data")
+		}
+		e.progress.addPoint()
+		e.written++
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package taggify
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultCheckpointInterval is the default number of lines written to a Writer between checkpoints.
+const DefaultCheckpointInterval = 100000
+
+// Checkpoint is the progress of a transformation writing to a Writer,
+// which allows an interrupted transformation to be resumed.
+type Checkpoint struct {
+	// Fields are the names of the fields converted to tags.
+	Fields []string `json:"fields"`
+	// Input identifies the input the checkpoint was created for, see Options.InputID.
+	Input string `json:"input"`
+	// Lines is the number of lines acknowledged by the Writer, in order they are written.
+	Lines int64 `json:"lines"`
+	// Offset is the offset in the decompressed input, up to which the data is acknowledged.
+	// Data in the data section is grouped, so Offset points to its start until all of it is acknowledged.
+	// If Offset points past the start of the data section, the data section is skipped without grouping
+	// on resume and the lines following it are skipped up to Offset.
+	Offset int64 `json:"offset"`
+}
+
+// resumeWriter wraps a Writer, skipping the lines acknowledged according to a Checkpoint
+// and saving a new Checkpoint every interval lines, after all lines written so far are acknowledged.
+type resumeWriter struct {
+	Writer
+	cp       Checkpoint
+	skip     int64
+	n        int
+	interval int
+	// offset points to the offset in the input of the data written last.
+	offset *int64
+	save   func(Checkpoint) error
+}
+
+func newResumeWriter(w Writer, cp Checkpoint, fields []string, input string, interval int, offset *int64, save func(Checkpoint) error) (*resumeWriter, error) {
+	if cp.Lines > 0 && !equalStrings(cp.Fields, fields) {
+		return nil, errors.Errorf("checkpoint was created for fields %v, not %v", cp.Fields, fields)
+	}
+	if cp.Lines > 0 && cp.Input != input {
+		return nil, errors.Errorf("checkpoint was created for input %s, not %s", cp.Input, input)
+	}
+	if interval <= 0 {
+		interval = DefaultCheckpointInterval
+	}
+	cp.Fields = fields
+	cp.Input = input
+	return &resumeWriter{
+		Writer:   w,
+		cp:       cp,
+		skip:     cp.Lines,
+		interval: interval,
+		offset:   offset,
+		save:     save,
+	}, nil
+}
+
+// WriteLine implements Writer.
+func (w *resumeWriter) WriteLine(ctx context.Context, db, rp string, line []byte) error {
+	if w.skip > 0 {
+		w.skip--
+		return nil
+	}
+	if err := w.Writer.WriteLine(ctx, db, rp, line); err != nil {
+		return err
+	}
+	w.cp.Lines++
+	if w.n++; w.n < w.interval {
+		return nil
+	}
+	return w.checkpoint(ctx)
+}
+
+// Flush implements Writer.
+func (w *resumeWriter) Flush(ctx context.Context) error {
+	if w.skip > 0 {
+		return errors.Errorf("input ended %d lines before the checkpoint, it does not match the input", w.skip)
+	}
+	return w.checkpoint(ctx)
+}
+
+// checkpoint waits until all lines are acknowledged and saves the checkpoint.
+func (w *resumeWriter) checkpoint(ctx context.Context) error {
+	if err := w.Writer.Flush(ctx); err != nil {
+		return err
+	}
+	w.n = 0
+	w.cp.Offset = *w.offset
+	if w.save == nil {
+		return nil
+	}
+	return errors.Wrap(w.save(w.cp), "failed to save checkpoint")
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package taggify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	a := assert.New(t)
+
+	var data, wal []string
+	var expected []string
+	for i := 0; i < 10; i++ {
+		data = append(data, fmt.Sprintf(`test,id=%d idd="bar" %d`, i, i), fmt.Sprintf(`test,id=%d value=%d %d`, i, i, i))
+		expected = append(expected, fmt.Sprintf(`test,id=%d,idd=bar value=%d %d`, i, i, i))
+	}
+	for i := 10; i < 15; i++ {
+		wal = append(wal, fmt.Sprintf(`test,id=%d value=%d %d`, i, i, i))
+		expected = append(expected, wal[len(wal)-1])
+	}
+	sort.Strings(expected)
+	input := strings.Join([]string{header, strings.Join(data, "\n"), footer, strings.Join(wal, "\n")}, "\n")
+
+	run := func(fake *fakeInflux, cp *Checkpoint) ([]Checkpoint, error) {
+		srv := httptest.NewServer(fake)
+		defer srv.Close()
+		w, err := NewHTTPWriter(HTTPConfig{
+			URL:         srv.URL,
+			BatchSize:   2,
+			Concurrency: 1,
+			Retries:     -1,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var saved []Checkpoint
+		_, err = Transform(context.Background(), strings.NewReader(input), nil, Options{
+			Fields:             []string{"idd"},
+			Writer:             w,
+			Checkpoint:         cp,
+			InputID:            "input",
+			CheckpointInterval: 4,
+			OnCheckpoint: func(cp Checkpoint) error {
+				saved = append(saved, cp)
+				return nil
+			},
+		})
+		return saved, err
+	}
+
+	fake := newFakeInflux()
+	fake.failAfter = 5
+	saved, err := run(fake, &Checkpoint{})
+	if a.Error(err) {
+		a.Contains(err.Error(), "500 Internal Server Error: engine: shutting down")
+	}
+	if !a.Len(saved, 2) {
+		t.FailNow()
+	}
+	cp := saved[1]
+	a.Equal([]string{"idd"}, cp.Fields)
+	a.Equal("input", cp.Input)
+	a.Equal(int64(8), cp.Lines)
+	a.Equal(int64(len(header)+1), cp.Offset, "offset must not pass the data section before all of it is written")
+	first := fake.sorted("test/autogen/ns")
+
+	fake = newFakeInflux()
+	saved, err = run(fake, &cp)
+	a.NoError(err)
+	if a.NotEmpty(saved) {
+		last := saved[len(saved)-1]
+		a.Equal(int64(len(expected)), last.Lines)
+		a.Equal(int64(len(input)), last.Offset)
+	}
+	second := fake.sorted("test/autogen/ns")
+	a.Len(second, len(expected)-8)
+
+	written := make(map[string]bool)
+	for _, line := range append(first, second...) {
+		written[line] = true
+	}
+	var all []string
+	for line := range written {
+		all = append(all, line)
+	}
+	sort.Strings(all)
+	a.Equal(expected, all)
+
+	fake = newFakeInflux()
+	_, err = run(fake, &Checkpoint{Fields: []string{"idd"}, Input: "input", Lines: 12, Offset: int64(strings.Index(input, wal[2]))})
+	a.NoError(err)
+	a.Equal(wal[2:], fake.sorted("test/autogen/ns"), "data section written again on resume past it")
+
+	_, err = run(newFakeInflux(), &Checkpoint{Fields: []string{"other"}, Input: "input", Lines: 1})
+	a.EqualError(err, "checkpoint was created for fields [other], not [idd]")
+
+	_, err = run(newFakeInflux(), &Checkpoint{Fields: []string{"idd"}, Input: "other", Lines: 1})
+	a.EqualError(err, "checkpoint was created for input other, not input")
+
+	_, err = run(newFakeInflux(), &Checkpoint{Fields: []string{"idd"}, Input: "input", Lines: 100})
+	a.EqualError(err, "input ended 85 lines before the checkpoint, it does not match the input")
+
+	_, err = run(newFakeInflux(), &Checkpoint{Fields: []string{"idd"}, Input: "input", Lines: 100, Offset: int64(len(input) + 10)})
+	a.EqualError(err, fmt.Sprintf("input ended at offset %d before the checkpoint at offset %d, it does not match the input", len(input), len(input)+10))
+}
+
+func TestDeadLetter(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo value=1 1
+test,id=foo value="bad" 2
+test,id=foo value=3 3`
+
+	fake := newFakeInflux()
+	fake.reject = func(body string) bool {
+		return strings.Contains(body, "bad")
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dead := &bytes.Buffer{}
+	w, err := NewHTTPWriter(HTTPConfig{
+		URL:        srv.URL,
+		BatchSize:  1,
+		DeadLetter: dead,
+	})
+	a.NoError(err)
+	_, err = Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, "\n")), nil, Options{
+		Writer:     w,
+		Checkpoint: &Checkpoint{},
+	})
+	a.NoError(err)
+	a.Equal([]string{"test,id=foo value=1 1", "test,id=foo value=3 3"}, fake.sorted("test/autogen/ns"))
+	a.Equal(`# database=test retention-policy=autogen precision=ns lines=1 error=400 Bad Request: partial write: field type conflict: input field "value" on measurement "test" is type string, already exists as type float dropped=1
+test,id=foo value="bad" 2
+`, dead.String())
+	a.Equal(int64(1), w.Rejected())
+}
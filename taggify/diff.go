@@ -0,0 +1,624 @@
+package taggify
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultDiffChunkSize is the default number of points of each input sorted in memory by Diff.
+const DefaultDiffChunkSize = 1 << 20
+
+// DiffConfig configures Diff.
+type DiffConfig struct {
+	// ChunkSize is the number of points of each input sorted in memory, DefaultDiffChunkSize if zero.
+	// Larger inputs are sorted in chunks of ChunkSize points written to temporary files, which are merged.
+	ChunkSize int
+	// TempDir is the directory temporary files are created in, the default directory for temporary files if empty.
+	TempDir string
+	// MaxDifferences, if positive, is the number of differences listed. All differences are counted regardless.
+	MaxDifferences int
+}
+
+// DiffStats are the numbers of differences found by Diff. Points are normalized to contain a single field.
+type DiffStats struct {
+	// AddedSeries and RemovedSeries are the numbers of series present only in the second or the first input.
+	AddedSeries   int64 `json:"added_series"`
+	RemovedSeries int64 `json:"removed_series"`
+	// AddedPoints and RemovedPoints are the numbers of points present only in the second or the first input,
+	// including the points of added and removed series.
+	AddedPoints   int64 `json:"added_points"`
+	RemovedPoints int64 `json:"removed_points"`
+	// ChangedValues is the number of points present in both inputs with a different value or type.
+	ChangedValues int64 `json:"changed_values"`
+	// UnchangedPoints is the number of points equal in both inputs.
+	UnchangedPoints int64 `json:"unchanged_points"`
+}
+
+// Identical reports whether no differences were found.
+func (s *DiffStats) Identical() bool {
+	return s.AddedPoints == 0 && s.RemovedPoints == 0 && s.ChangedValues == 0
+}
+
+func (s *DiffStats) String() string {
+	return fmt.Sprintf("Series: %d added, %d removed. Points: %d added, %d removed, %d changed, %d unchanged",
+		s.AddedSeries, s.RemovedSeries, s.AddedPoints, s.RemovedPoints, s.ChangedValues, s.UnchangedPoints)
+}
+
+// diffEntry is a normalized point with a single field.
+type diffEntry struct {
+	dbrp
+	series string
+	time   int64
+	field  string
+	// value is the line protocol representation of the value, which includes its type.
+	value string
+	// seq is the position of the entry in its input, which orders duplicate points.
+	seq uint64
+}
+
+func compareStrings(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareSeries compares the database, retention policy and series key of a and b.
+func compareSeries(a, b *diffEntry) int {
+	if c := compareStrings(a.db, b.db); c != 0 {
+		return c
+	}
+	if c := compareStrings(a.rp, b.rp); c != 0 {
+		return c
+	}
+	return compareStrings(a.series, b.series)
+}
+
+// comparePoints compares the series, time and field of a and b.
+func comparePoints(a, b *diffEntry) int {
+	if c := compareSeries(a, b); c != 0 {
+		return c
+	}
+	switch {
+	case a.time < b.time:
+		return -1
+	case a.time > b.time:
+		return 1
+	}
+	return compareStrings(a.field, b.field)
+}
+
+// compareEntries compares the points of a and b and, if they are equal, their positions in the input.
+func compareEntries(a, b *diffEntry) int {
+	if c := comparePoints(a, b); c != 0 {
+		return c
+	}
+	switch {
+	case a.seq < b.seq:
+		return -1
+	case a.seq > b.seq:
+		return 1
+	}
+	return 0
+}
+
+func writeEntry(w *bufio.Writer, e *diffEntry) error {
+	var buf [binary.MaxVarintLen64]byte
+	for _, s := range []string{e.db, e.rp, e.series, e.field, e.value} {
+		w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+		w.WriteString(s)
+	}
+	w.Write(buf[:binary.PutVarint(buf[:], e.time)])
+	_, err := w.Write(buf[:binary.PutUvarint(buf[:], e.seq)])
+	return err
+}
+
+func readEntryString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// readEntry reads an entry written by writeEntry from r. It returns io.EOF if r is at its end.
+func readEntry(r *bufio.Reader) (diffEntry, error) {
+	var e diffEntry
+	var err error
+	for i, s := range []*string{&e.db, &e.rp, &e.series, &e.field, &e.value} {
+		if *s, err = readEntryString(r); err == io.EOF && i > 0 {
+			return e, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return e, err
+		}
+	}
+	if e.time, err = binary.ReadVarint(r); err == io.EOF {
+		return e, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return e, err
+	}
+	if e.seq, err = binary.ReadUvarint(r); err == io.EOF {
+		return e, io.ErrUnexpectedEOF
+	}
+	return e, err
+}
+
+// entryIterator iterates over sorted entries. next returns io.EOF after the last entry.
+type entryIterator interface {
+	next() (diffEntry, error)
+}
+
+type sliceIterator []diffEntry
+
+func (it *sliceIterator) next() (diffEntry, error) {
+	if len(*it) == 0 {
+		return diffEntry{}, io.EOF
+	}
+	e := (*it)[0]
+	*it = (*it)[1:]
+	return e, nil
+}
+
+type fileIterator struct {
+	r *bufio.Reader
+}
+
+func (it fileIterator) next() (diffEntry, error) {
+	return readEntry(it.r)
+}
+
+// mergeHead is the current entry of an iterator merged by mergeIterator.
+type mergeHead struct {
+	it entryIterator
+	e  diffEntry
+}
+
+// mergeIterator merges sorted iterators.
+type mergeIterator []*mergeHead
+
+func (h mergeIterator) Len() int            { return len(h) }
+func (h mergeIterator) Less(i, j int) bool  { return compareEntries(&h[i].e, &h[j].e) < 0 }
+func (h mergeIterator) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeIterator) Push(x interface{}) { *h = append(*h, x.(*mergeHead)) }
+func (h *mergeIterator) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func (h *mergeIterator) next() (diffEntry, error) {
+	if len(*h) == 0 {
+		return diffEntry{}, io.EOF
+	}
+	top := (*h)[0]
+	e := top.e
+	next, err := top.it.next()
+	switch {
+	case err == io.EOF:
+		heap.Pop(h)
+	case err != nil:
+		return e, err
+	default:
+		top.e = next
+		heap.Fix(h, 0)
+	}
+	return e, nil
+}
+
+// lastValueIterator yields only the last entry of each point of a sorted iterator, since InfluxDB keeps the last write.
+type lastValueIterator struct {
+	it      entryIterator
+	head    diffEntry
+	ok, eof bool
+}
+
+// read reads the next entry of it.it into it.head.
+func (it *lastValueIterator) read() error {
+	e, err := it.it.next()
+	if err == io.EOF {
+		it.ok, it.eof = false, true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	it.head, it.ok = e, true
+	return nil
+}
+
+func (it *lastValueIterator) next() (diffEntry, error) {
+	if !it.ok && !it.eof {
+		if err := it.read(); err != nil {
+			return diffEntry{}, err
+		}
+	}
+	if !it.ok {
+		return diffEntry{}, io.EOF
+	}
+	e := it.head
+	for {
+		if err := it.read(); err != nil {
+			return e, err
+		}
+		if !it.ok || comparePoints(&it.head, &e) != 0 {
+			return e, nil
+		}
+		e = it.head
+	}
+}
+
+// maxMergeFiles is the maximum number of temporary files merged at once, which bounds the number of open files.
+const maxMergeFiles = 64
+
+// entrySorter sorts entries in chunks of bounded size, which are written to temporary files if there is more than one.
+// The temporary files are closed once written and merged at most maxMergeFiles at a time.
+type entrySorter struct {
+	chunkSize int
+	dir       string
+	entries   []diffEntry
+	// files are the paths of the temporary files, each containing a sorted run of entries.
+	files []string
+	// open are the temporary files opened by merge.
+	open []*os.File
+	seq  uint64
+}
+
+func (s *entrySorter) add(e diffEntry) error {
+	e.seq = s.seq
+	s.seq++
+	s.entries = append(s.entries, e)
+	if len(s.entries) < s.chunkSize {
+		return nil
+	}
+	return s.spill()
+}
+
+func (s *entrySorter) sort() {
+	sort.Slice(s.entries, func(i, j int) bool { return compareEntries(&s.entries[i], &s.entries[j]) < 0 })
+}
+
+// spill writes the sorted entries to a temporary file.
+func (s *entrySorter) spill() error {
+	s.sort()
+	it := sliceIterator(s.entries)
+	path, err := s.writeTemp(&it)
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, path)
+	s.entries = s.entries[:0]
+	return nil
+}
+
+// writeTemp writes the entries of it to a new temporary file and returns its path.
+func (s *entrySorter) writeTemp(it entryIterator) (path string, err error) {
+	f, err := ioutil.TempFile(s.dir, "influx-taggify-diff-")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temporary file")
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = errors.Wrap(cerr, "failed to write temporary file")
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	w := bufio.NewWriter(f)
+	for {
+		e, err := it.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read temporary file")
+		}
+		if err := writeEntry(w, &e); err != nil {
+			return "", errors.Wrap(err, "failed to write temporary file")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", errors.Wrap(err, "failed to write temporary file")
+	}
+	return f.Name(), nil
+}
+
+// merge opens the temporary files at paths and returns an iterator merging them.
+// The files are closed by closeOpen.
+func (s *entrySorter) merge(paths []string) (*mergeIterator, error) {
+	h := make(mergeIterator, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open temporary file")
+		}
+		s.open = append(s.open, f)
+		it := fileIterator{r: bufio.NewReader(f)}
+		e, err := it.next()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read temporary file")
+		}
+		h = append(h, &mergeHead{it: it, e: e})
+	}
+	heap.Init(&h)
+	return &h, nil
+}
+
+// closeOpen closes the temporary files opened by merge.
+func (s *entrySorter) closeOpen() {
+	for _, f := range s.open {
+		f.Close()
+	}
+	s.open = nil
+}
+
+// iterator returns an iterator over all added entries in sorted order, with only the last entry of each point.
+func (s *entrySorter) iterator() (entryIterator, error) {
+	if len(s.files) == 0 {
+		s.sort()
+		it := sliceIterator(s.entries)
+		return &lastValueIterator{it: &it}, nil
+	}
+	if len(s.entries) > 0 {
+		if err := s.spill(); err != nil {
+			return nil, err
+		}
+	}
+	// merge the oldest files into one, until the rest can be merged at once
+	for len(s.files) > maxMergeFiles {
+		h, err := s.merge(s.files[:maxMergeFiles])
+		if err != nil {
+			return nil, err
+		}
+		path, err := s.writeTemp(h)
+		s.closeOpen()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range s.files[:maxMergeFiles] {
+			os.Remove(p)
+		}
+		s.files = append(s.files[maxMergeFiles:], path)
+	}
+	h, err := s.merge(s.files)
+	if err != nil {
+		return nil, err
+	}
+	return &lastValueIterator{it: h}, nil
+}
+
+// close closes and removes the temporary files.
+func (s *entrySorter) close() {
+	s.closeOpen()
+	for _, path := range s.files {
+		os.Remove(path)
+	}
+	s.files = nil
+}
+
+// entryStream is an entryIterator with its current entry.
+type entryStream struct {
+	it entryIterator
+	e  diffEntry
+	ok bool
+}
+
+func (s *entryStream) advance() error {
+	e, err := s.it.next()
+	if err == io.EOF {
+		s.ok = false
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read sorted points")
+	}
+	s.e, s.ok = e, true
+	return nil
+}
+
+// differ writes the differences of two sorted streams.
+type differ struct {
+	w     *bufio.Writer
+	max   int
+	n     int
+	stats DiffStats
+}
+
+func (d *differ) list(format string, args ...interface{}) {
+	if d.max > 0 && d.n == d.max {
+		return
+	}
+	d.n++
+	fmt.Fprintf(d.w, format, args...)
+}
+
+func location(e *diffEntry) string {
+	if e.db == "" {
+		return e.series
+	}
+	return e.db + "/" + e.rp + ": " + e.series
+}
+
+// skipSeries advances s past the series of its current entry and returns the number of skipped entries.
+func skipSeries(ctx context.Context, s *entryStream) (int64, error) {
+	series := s.e
+	var n int64
+	for s.ok && compareSeries(&s.e, &series) == 0 {
+		if n%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return n, err
+			}
+		}
+		n++
+		if err := s.advance(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// diffSeries compares the entries of the series, which is current in both a and b.
+func (d *differ) diffSeries(ctx context.Context, a, b *entryStream) error {
+	series := a.e
+	for {
+		inA := a.ok && compareSeries(&a.e, &series) == 0
+		inB := b.ok && compareSeries(&b.e, &series) == 0
+		c := 0
+		switch {
+		case !inA && !inB:
+			return nil
+		case !inA:
+			c = 1
+		case !inB:
+			c = -1
+		default:
+			c = comparePoints(&a.e, &b.e)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var err error
+		switch {
+		case c < 0:
+			d.stats.RemovedPoints++
+			d.list("- %s %s=%s %d\n", location(&a.e), a.e.field, a.e.value, a.e.time)
+			err = a.advance()
+		case c > 0:
+			d.stats.AddedPoints++
+			d.list("+ %s %s=%s %d\n", location(&b.e), b.e.field, b.e.value, b.e.time)
+			err = b.advance()
+		default:
+			if a.e.value == b.e.value {
+				d.stats.UnchangedPoints++
+			} else {
+				d.stats.ChangedValues++
+				d.list("~ %s %s=%s %d (was %s)\n", location(&b.e), b.e.field, b.e.value, b.e.time, a.e.value)
+			}
+			if err = a.advance(); err == nil {
+				err = b.advance()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readSorted reads the points of the export or line protocol in r into s.
+func readSorted(ctx context.Context, r io.Reader, s *entrySorter) error {
+	return readExport(ctx, r, func(db, rp string, p *Point) error {
+		series := p.SeriesKey()
+		for _, f := range p.Fields {
+			if err := s.add(diffEntry{
+				dbrp:   dbrp{db: db, rp: rp},
+				series: series,
+				time:   p.Time,
+				field:  f.Key,
+				value:  string(appendValue(nil, f.Value)),
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Diff compares the exports or line protocol in a and b point by point regardless of the order of the points,
+// and writes the differences to w. Points are normalized to a single field with sorted tags and typed value.
+// If a point occurs more than once in an input, only its last value is compared, as InfluxDB keeps the last write.
+// Series present only in one of the inputs are listed as a whole, prefixed by '-' if removed and '+' if added.
+// Points of series present in both are listed likewise, points with changed values are prefixed by '~'.
+// The inputs are sorted in chunks of conf.ChunkSize points, which bounds memory usage.
+func Diff(ctx context.Context, a, b io.Reader, w io.Writer, conf DiffConfig) (*DiffStats, error) {
+	if conf.ChunkSize <= 0 {
+		conf.ChunkSize = DefaultDiffChunkSize
+	}
+	sa := &entrySorter{chunkSize: conf.ChunkSize, dir: conf.TempDir}
+	defer sa.close()
+	if err := readSorted(ctx, a, sa); err != nil {
+		return nil, errors.Wrap(err, "failed to read first input")
+	}
+	sb := &entrySorter{chunkSize: conf.ChunkSize, dir: conf.TempDir}
+	defer sb.close()
+	if err := readSorted(ctx, b, sb); err != nil {
+		return nil, errors.Wrap(err, "failed to read second input")
+	}
+
+	ia, err := sa.iterator()
+	if err != nil {
+		return nil, err
+	}
+	ib, err := sb.iterator()
+	if err != nil {
+		return nil, err
+	}
+	as, bs := &entryStream{it: ia}, &entryStream{it: ib}
+	if err := as.advance(); err != nil {
+		return nil, err
+	}
+	if err := bs.advance(); err != nil {
+		return nil, err
+	}
+
+	d := &differ{w: bufio.NewWriter(w), max: conf.MaxDifferences}
+	for as.ok || bs.ok {
+		c := 0
+		switch {
+		case !bs.ok:
+			c = -1
+		case !as.ok:
+			c = 1
+		default:
+			c = compareSeries(&as.e, &bs.e)
+		}
+		switch {
+		case c < 0:
+			e := as.e
+			n, err := skipSeries(ctx, as)
+			if err != nil {
+				return nil, err
+			}
+			d.stats.RemovedSeries++
+			d.stats.RemovedPoints += n
+			d.list("- series %s (%d points)\n", location(&e), n)
+		case c > 0:
+			e := bs.e
+			n, err := skipSeries(ctx, bs)
+			if err != nil {
+				return nil, err
+			}
+			d.stats.AddedSeries++
+			d.stats.AddedPoints += n
+			d.list("+ series %s (%d points)\n", location(&e), n)
+		default:
+			if err := d.diffSeries(ctx, as, bs); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := d.w.Flush(); err != nil {
+		return nil, errors.Wrap(err, "failed to write differences")
+	}
+	return &d.stats, nil
+}
@@ -0,0 +1,131 @@
+package taggify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	a := assert.New(t)
+
+	first := strings.Join([]string{header, `test,id=foo idd="bar",int=42i 1511629912071663075
+test,id=foo value=1.5 1511629912071663076
+test,id=bar value=2 1511629912071663075
+test,id=bar value=3 1511629912071663076
+cpu,host=a usage=0.5 1511629912071663075`, footer}, "\n")
+	second := strings.Join([]string{header, `test,id=bar value=3 1511629912071663076
+test,id=foo int=43i 1511629912071663075
+test,id=foo idd="bar" 1511629912071663075
+test,id=foo value=1.5,extra=true 1511629912071663076
+cpu,host=a usage=0.5 1511629912071663075
+test,id=qux value=4 1511629912071663077
+test,id=bar value=2i 1511629912071663075`, footer}, "\n")
+
+	expected := `~ test/autogen: test,id=bar value=2i 1511629912071663075 (was 2)
+~ test/autogen: test,id=foo int=43i 1511629912071663075 (was 42i)
++ test/autogen: test,id=foo extra=true 1511629912071663076
++ series test/autogen: test,id=qux (1 points)
+`
+	for _, chunkSize := range []int{0, 2} {
+		dir := t.TempDir()
+		out := &bytes.Buffer{}
+		st, err := Diff(context.Background(), strings.NewReader(first), strings.NewReader(second), out, DiffConfig{ChunkSize: chunkSize, TempDir: dir})
+		if !a.NoError(err) {
+			t.FailNow()
+		}
+		a.Equal(expected, out.String())
+		a.Equal(&DiffStats{
+			AddedSeries:     1,
+			AddedPoints:     2,
+			ChangedValues:   2,
+			UnchangedPoints: 4,
+		}, st)
+		a.False(st.Identical())
+
+		fis, err := ioutil.ReadDir(dir)
+		a.NoError(err)
+		a.Empty(fis)
+	}
+
+	out := &bytes.Buffer{}
+	st, err := Diff(context.Background(), strings.NewReader(second), strings.NewReader(first), out, DiffConfig{MaxDifferences: 3})
+	a.NoError(err)
+	a.Equal(`~ test/autogen: test,id=bar value=2 1511629912071663075 (was 2i)
+~ test/autogen: test,id=foo int=42i 1511629912071663075 (was 43i)
+- test/autogen: test,id=foo extra=true 1511629912071663076
+`, out.String())
+	a.Equal("Series: 0 added, 1 removed. Points: 0 added, 2 removed, 2 changed, 4 unchanged", st.String())
+
+	out.Reset()
+	st, err = Diff(context.Background(), strings.NewReader("test b=1,a=2 1\ntest,y=1,x=2 a=1 1\n"), strings.NewReader("test,x=2,y=1 a=1 1\ntest a=2 1\ntest b=1 1\n"), out, DiffConfig{ChunkSize: 1})
+	a.NoError(err)
+	a.True(st.Identical())
+	a.Empty(out.String())
+
+	for _, chunkSize := range []int{0, 1} {
+		out.Reset()
+		st, err = Diff(context.Background(),
+			strings.NewReader("m,t=a x=1 1\nm,t=a x=2 1\nm,t=b y=1 1\nm,t=b y=1 1\n"),
+			strings.NewReader("m,t=b y=1 1\nm,t=a x=2 1\n"),
+			out, DiffConfig{ChunkSize: chunkSize})
+		a.NoError(err)
+		a.True(st.Identical(), out.String())
+		a.Equal(int64(2), st.UnchangedPoints)
+	}
+
+	out.Reset()
+	st, err = Diff(context.Background(), strings.NewReader("m x=1 1\nm x=2 1\n"), strings.NewReader("m x=2 1\nm x=1 1\n"), out, DiffConfig{})
+	a.NoError(err)
+	a.Equal("~ m x=1 1 (was 2)\n", out.String())
+
+	// a conversion without fields deduplicates the export
+	original := strings.Join([]string{header, "test,id=foo value=1 1", "test,id=foo value=1 1", footer}, "\n")
+	converted := &bytes.Buffer{}
+	_, err = Transform(context.Background(), strings.NewReader(original), converted, Options{})
+	a.NoError(err)
+	out.Reset()
+	st, err = Diff(context.Background(), strings.NewReader(original), converted, out, DiffConfig{})
+	a.NoError(err)
+	a.True(st.Identical(), out.String())
+
+	_, err = Diff(context.Background(), strings.NewReader(first), strings.NewReader("test value=\n"), out, DiffConfig{})
+	a.Error(err)
+}
+
+func TestDiffManyChunks(t *testing.T) {
+	a := assert.New(t)
+
+	// with a chunk size of 1, the temporary files are merged in several passes
+	n := 3 * maxMergeFiles
+	var first, second []string
+	for i := 0; i < n; i++ {
+		first = append(first, fmt.Sprintf("m,t=a x=%d %d", i, i))
+		v := n - 1 - i
+		if v == 7 {
+			second = append(second, fmt.Sprintf("m,t=a x=70 %d", v))
+		} else {
+			second = append(second, fmt.Sprintf("m,t=a x=%d %d", v, v))
+		}
+	}
+	// the last write of the point is merged in a later pass than the first one
+	first = append(first, "m,t=a x=100 5")
+	second = append(second, "m,t=a x=100 5")
+
+	dir := t.TempDir()
+	out := &bytes.Buffer{}
+	st, err := Diff(context.Background(), strings.NewReader(strings.Join(first, "\n")), strings.NewReader(strings.Join(second, "\n")), out,
+		DiffConfig{ChunkSize: 1, TempDir: dir})
+	a.NoError(err)
+	a.Equal("~ m,t=a x=70 7 (was 7)\n", out.String())
+	a.Equal(int64(n-1), st.UnchangedPoints)
+
+	fis, err := ioutil.ReadDir(dir)
+	a.NoError(err)
+	a.Empty(fis)
+}
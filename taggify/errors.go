@@ -0,0 +1,87 @@
+package taggify
+
+import (
+	"fmt"
+)
+
+// maxExcerptLength is the maximum length of ParseError.Excerpt.
+const maxExcerptLength = 64
+
+// ParseError is returned when a line of input fails to parse.
+type ParseError struct {
+	// Section is the section of the export the line is in.
+	Section string
+	// Line is the 1-based number of the line in input.
+	Line int
+	// Offset is the byte offset of the line in input.
+	Offset int64
+	// Column is the 1-based byte offset within the line, where the error occurred.
+	Column int
+	// Excerpt is the token of the line, which caused the error, truncated to at most maxExcerptLength bytes.
+	Excerpt string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	s := fmt.Sprintf("%s section, line %d, column %d, offset %d: %s", e.Section, e.Line, e.Column, e.Offset, e.Err)
+	if e.Excerpt != "" {
+		s += fmt.Sprintf(" near %q", e.Excerpt)
+	}
+	return s
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Cause implements github.com/pkg/errors causer interface.
+func (e *ParseError) Cause() error {
+	return e.Err
+}
+
+// newParseError returns a new *ParseError caused by err at byte pos of line.
+func newParseError(line []byte, pos int, err error) *ParseError {
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > len(line) {
+		pos = len(line)
+	}
+	return &ParseError{
+		Column:  pos + 1,
+		Excerpt: excerpt(line, pos),
+		Err:     err,
+	}
+}
+
+// excerpt returns the token of line containing byte pos.
+// Tokens are delimited by unescaped spaces and commas.
+func excerpt(line []byte, pos int) string {
+	isDelim := func(i int) bool {
+		return (line[i] == ' ' || line[i] == ',') && (i == 0 || line[i-1] != '\\')
+	}
+
+	start := pos
+	if start == len(line) && start > 0 {
+		start--
+	}
+	for start > 0 && !isDelim(start-1) {
+		start--
+	}
+	end := pos
+	for end < len(line) && (end == start || !isDelim(end)) {
+		end++
+	}
+	if end-start <= maxExcerptLength {
+		return string(line[start:end])
+	}
+	// keep the region around pos
+	if pos-start > maxExcerptLength/2 {
+		start = pos - maxExcerptLength/2
+	}
+	if end-start > maxExcerptLength {
+		end = start + maxExcerptLength
+	}
+	return string(line[start:end])
+}
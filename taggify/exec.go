@@ -0,0 +1,208 @@
+package taggify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxStderrLength is the maximum number of trailing bytes of stderr of a command included in errors.
+const maxStderrLength = 1024
+
+// ExecBatchEnd is the line written to the command run by Exec after each batch of points.
+// The command must write it back once it has written the transformed points of the batch.
+const ExecBatchEnd = "# end of batch"
+
+// errExited is returned by Exec.roundTrip if the command closes stdout before returning the batch.
+var errExited = errors.New("command exited")
+
+// tailBuffer retains the last maxStderrLength bytes written to it.
+type tailBuffer struct {
+	bytes.Buffer
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n, _ := b.Buffer.Write(p)
+	if b.Len() > maxStderrLength {
+		b.Next(b.Len() - maxStderrLength)
+	}
+	return n, nil
+}
+
+// Exec is a BatchTransformer, which streams batches of points through a long-running external command.
+// The command is started on the first batch. Each batch is written to stdin of the command as line protocol
+// followed by ExecBatchEnd, and the transformed points are read back from its stdout, in order, up to the line
+// ExecBatchEnd, which the command must write back and flush after the transformed points of each batch.
+// Empty lines and other lines starting with '#' in the output are ignored.
+// The transformation fails if the command exits, outputs a line, which fails to parse, or does not return a batch
+// within Timeout. The command is stopped on failure and must be stopped by Close once all batches are transformed.
+type Exec struct {
+	// Path is the path of the command to run.
+	Path string
+	// Args are the arguments passed to the command.
+	Args []string
+	// Timeout, if positive, is the maximum duration the command may take to return a single batch.
+	Timeout time.Duration
+	// Stderr, if not nil, is where stderr of the command is written to.
+	Stderr io.Writer
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *tailBuffer
+	// err is the error the command failed with, after which no more batches are transformed.
+	err error
+}
+
+// start starts the command.
+func (e *Exec) start() error {
+	cmd := exec.Command(e.Path, e.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	e.stderr = &tailBuffer{}
+	cmd.Stderr = e.stderr
+	if e.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(e.stderr, e.Stderr)
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "failed to start command %s", e.Path)
+	}
+	e.cmd, e.stdin, e.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// wait waits for the command to exit and returns its exit error along with the tail of stderr.
+// If the command exited successfully, err is returned instead.
+func (e *Exec) wait(err error) error {
+	werr := e.cmd.Wait()
+	e.cmd = nil
+	if werr == nil {
+		return err
+	}
+	if msg := strings.TrimSpace(e.stderr.String()); msg != "" {
+		return errors.Wrapf(werr, "command %s failed: %s", e.Path, msg)
+	}
+	return errors.Wrapf(werr, "command %s failed", e.Path)
+}
+
+// roundTrip writes batch to the command and reads the transformed points back.
+// It does not modify e, so that the command can be killed while it runs.
+func (e *Exec) roundTrip(batch []byte) ([]*Point, error) {
+	written := make(chan error, 1)
+	go func() {
+		_, err := e.stdin.Write(batch)
+		written <- err
+	}()
+
+	var res []*Point
+	for n := 1; ; n++ {
+		line, err := e.stdout.ReadBytes('\n')
+		if err == io.EOF {
+			return nil, errExited
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read output of command %s", e.Path)
+		}
+		line = bytes.TrimSpace(line)
+		if string(line) == ExecBatchEnd {
+			break
+		}
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		p, err := ParsePoint(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse line %d of output of command %s for the batch", n, e.Path)
+		}
+		res = append(res, p)
+	}
+	if err := <-written; err != nil {
+		return nil, errors.Wrapf(err, "failed to write to command %s", e.Path)
+	}
+	return res, nil
+}
+
+// kill stops the command and waits for it to exit.
+func (e *Exec) kill() {
+	e.cmd.Process.Kill()
+	e.cmd.Wait()
+	e.cmd = nil
+}
+
+// TransformBatch implements BatchTransformer.
+func (e *Exec) TransformBatch(ctx context.Context, points []*Point) ([]*Point, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	if e.cmd == nil {
+		if err := e.start(); err != nil {
+			return nil, err
+		}
+	}
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	var in []byte
+	for _, p := range points {
+		in = append(p.AppendLine(in), '\n')
+	}
+	in = append(append(in, ExecBatchEnd...), '\n')
+
+	type result struct {
+		points []*Point
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		points, err := e.roundTrip(in)
+		done <- result{points, err}
+	}()
+	select {
+	case res := <-done:
+		switch res.err {
+		case nil:
+			return res.points, nil
+		case errExited:
+			e.err = e.wait(errors.Errorf("command %s exited before returning the batch", e.Path))
+		default:
+			e.kill()
+			e.err = res.err
+		}
+	case <-ctx.Done():
+		// killing the command closes the pipes, which unblocks roundTrip
+		e.kill()
+		<-done
+		e.err = ctx.Err()
+		if e.err == context.DeadlineExceeded {
+			e.err = errors.Errorf("command %s timed out after %s", e.Path, e.Timeout)
+		}
+	}
+	return nil, e.err
+}
+
+// Close closes stdin of the command and waits for it to exit. It returns an error if the command fails.
+func (e *Exec) Close() error {
+	if e.cmd == nil {
+		return nil
+	}
+	e.stdin.Close()
+	// output following the last batch is discarded
+	io.Copy(ioutil.Discard, e.stdout)
+	return e.wait(nil)
+}
@@ -0,0 +1,142 @@
+package taggify
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExec(t *testing.T) {
+	a := assert.New(t)
+
+	var points []*Point
+	for _, line := range []string{
+		`test,id=foo value=1 1`,
+		`test,id=bar value=2 2`,
+	} {
+		p, err := ParsePoint([]byte(line))
+		a.NoError(err)
+		points = append(points, p)
+	}
+
+	e := &Exec{
+		Path: "sh",
+		Args: []string{"-c", `n=0
+while IFS= read -r l; do
+	case "$l" in
+	"# end of batch")
+		n=$((n+1))
+		echo "# comment"
+		echo
+		echo "batch value=$n 3"
+		echo "$l";;
+	*id=bar*) ;;
+	test*) echo "renamed${l#test}";;
+	esac
+done`},
+	}
+	for i, expected := range []string{`batch value=1 3`, `batch value=2 3`} {
+		res, err := e.TransformBatch(context.Background(), points)
+		a.NoError(err)
+		if a.Len(res, 2) {
+			a.Equal(`renamed,id=foo value=1 1`, res[0].String())
+			a.Equal(expected, res[1].String(), "batch %d not transformed by the same process", i)
+		}
+	}
+	a.NoError(e.Close())
+
+	e = &Exec{
+		Path: "sh",
+		Args: []string{"-c", `read l; echo boom >&2; exit 3`},
+	}
+	_, err := e.TransformBatch(context.Background(), points)
+	a.EqualError(err, "command sh failed: boom: exit status 3")
+	_, err = e.TransformBatch(context.Background(), points)
+	a.EqualError(err, "command sh failed: boom: exit status 3")
+
+	_, err = (&Exec{
+		Path: "sh",
+		Args: []string{"-c", `exit 0`},
+	}).TransformBatch(context.Background(), points)
+	a.EqualError(err, "command sh exited before returning the batch")
+
+	_, err = (&Exec{
+		Path: "sh",
+		Args: []string{"-c", `while read l; do echo invalid; done`},
+	}).TransformBatch(context.Background(), points)
+	if a.Error(err) {
+		a.Contains(err.Error(), "failed to parse line 1 of output of command sh")
+	}
+
+	e = &Exec{
+		Path: "sh",
+		Args: []string{"-c", `while IFS= read -r l; do case "$l" in "# end of batch") echo "$l";; esac; done; exit 4`},
+	}
+	res, err := e.TransformBatch(context.Background(), points)
+	a.NoError(err)
+	a.Empty(res)
+	a.EqualError(e.Close(), "command sh failed: exit status 4")
+
+	start := time.Now()
+	_, err = (&Exec{
+		Path:    "sleep",
+		Args:    []string{"10"},
+		Timeout: 100 * time.Millisecond,
+	}).TransformBatch(context.Background(), points)
+	a.EqualError(err, "command sleep timed out after 100ms")
+	a.True(time.Since(start) < 5*time.Second)
+}
+
+type recordingBatchTransformer struct {
+	sizes []int
+}
+
+func (t *recordingBatchTransformer) TransformBatch(ctx context.Context, points []*Point) ([]*Point, error) {
+	t.sizes = append(t.sizes, len(points))
+	return points, nil
+}
+
+func TestTransformBatch(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo value=1 1
+test,id=foo value=2 2
+test,id=foo value=3 3
+test,id=foo value=4 4
+test,id=foo value=5 5`
+
+	bt := &recordingBatchTransformer{}
+	out := &bytes.Buffer{}
+	_, err := Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), out, Options{
+		BatchTransformer: bt,
+		BatchSize:        2,
+	})
+	a.NoError(err)
+	a.Equal([]int{2, 2, 1}, bt.sizes)
+	a.Equal(5, strings.Count(out.String(), "test,id=foo value="))
+
+	out.Reset()
+	e := &Exec{
+		Path: "sh",
+		Args: []string{"-c", `while IFS= read -r l; do case "$l" in test,*) l="renamed${l#test}";; esac; echo "$l"; done`},
+	}
+	_, err = Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), out, Options{
+		BatchTransformer: e,
+		BatchSize:        2,
+	})
+	a.NoError(err)
+	a.NoError(e.Close())
+	a.Equal(5, strings.Count(out.String(), "renamed,id=foo value="))
+
+	_, err = Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), &bytes.Buffer{}, Options{
+		BatchTransformer: &Exec{
+			Path: "sh",
+			Args: []string{"-c", `exit 1`},
+		},
+	})
+	a.EqualError(err, "batch transformer failed: command sh failed: exit status 1")
+}
@@ -0,0 +1,73 @@
+package taggify
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// errStop may be returned by the callback of readExport to stop reading without an error.
+var errStop = errors.New("stop reading")
+
+// readExport reads the points of an export produced by influx_inspect or of plain line protocol from r
+// and calls f for each of them along with its database and retention policy, which are empty for plain line protocol.
+// Unlike Transform, readExport does not group the data, so f is called for each line.
+// A line, which fails to parse, is reported as a *ParseError.
+func readExport(ctx context.Context, r io.Reader, f func(db, rp string, p *Point) error) error {
+	return readExportSections(ctx, r, func(_ Section, db, rp string, p *Point) error {
+		return f(db, rp, p)
+	})
+}
+
+// readExportSections is like readExport, but also passes the section of each point to f,
+// which is SectionStream for plain line protocol.
+func readExportSections(ctx context.Context, r io.Reader, f func(section Section, db, rp string, p *Point) error) error {
+	sc := newLineReader(r, 0)
+	var db, rp string
+	ddl := false
+	section := SectionStream
+	for sc.Scan() {
+		if sc.n%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		line := sc.Bytes()
+		switch {
+		case len(line) == 0:
+			continue
+		case line[0] == '#':
+			text := sc.Text()
+			switch {
+			case parseContext(text, &db, &rp):
+			case text == "# DDL":
+				ddl, section = true, SectionHeader
+			case text == "# DML":
+				ddl = false
+			case strings.HasPrefix(text, startLine):
+				section = SectionTSM
+			case strings.HasPrefix(text, stopLine):
+				section = SectionWAL
+			}
+			continue
+		case ddl:
+			continue
+		}
+		_, p, err := parsePoint(line)
+		if err != nil {
+			perr := err.(*ParseError)
+			perr.Section = section.String()
+			perr.Line = sc.n
+			perr.Offset = sc.offset
+			return perr
+		}
+		if err := f(section, db, rp, p); err == errStop {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
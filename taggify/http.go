@@ -0,0 +1,480 @@
+package taggify
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Writer writes line protocol to a database, such as InfluxDB.
+type Writer interface {
+	// WriteLine writes line of line protocol without the trailing newline to database db and retention policy rp.
+	// Timestamps in line are in nanoseconds. Implementations may buffer lines and must not retain line.
+	WriteLine(ctx context.Context, db, rp string, line []byte) error
+	// Flush writes any buffered lines and waits until all writes complete.
+	Flush(ctx context.Context) error
+}
+
+const (
+	// DefaultHTTPBatchSize is the default number of lines posted by HTTPWriter at once.
+	DefaultHTTPBatchSize = 5000
+	// DefaultHTTPConcurrency is the default number of concurrent requests made by HTTPWriter.
+	DefaultHTTPConcurrency = 4
+	// DefaultHTTPRetries is the default number of times HTTPWriter retries a failed request.
+	DefaultHTTPRetries = 3
+	// DefaultHTTPRetryInterval is the default delay before the first retry of a failed request.
+	DefaultHTTPRetryInterval = time.Second
+)
+
+// maxRetryInterval is the maximum delay between retries of a failed request.
+const maxRetryInterval = time.Minute
+
+// maxErrorBodyLength is the maximum number of bytes of an error response body included in errors.
+const maxErrorBodyLength = 1024
+
+// precisions maps the supported precisions to the number of nanoseconds in a unit.
+var precisions = map[string]int64{
+	"ns": 1,
+	"u":  int64(time.Microsecond),
+	"ms": int64(time.Millisecond),
+	"s":  int64(time.Second),
+	"m":  int64(time.Minute),
+	"h":  int64(time.Hour),
+}
+
+// HTTPConfig configures a HTTPWriter.
+// Lines are written to the /api/v2/write endpoint of InfluxDB 2.x if Org is specified,
+// and to the /write endpoint of InfluxDB 1.x otherwise.
+type HTTPConfig struct {
+	// URL is the base URL of InfluxDB, e.g. http://localhost:8086.
+	URL string
+	// Database, if not empty, overrides the database of written lines.
+	Database string
+	// RetentionPolicy, if not empty, overrides the retention policy of written lines.
+	RetentionPolicy string
+	// Username and Password are used for authentication with InfluxDB 1.x, if Username is not empty.
+	Username string
+	Password string
+
+	// Org is the organization to write to in InfluxDB 2.x.
+	Org string
+	// Bucket, if not empty, is the bucket all lines are written to in InfluxDB 2.x.
+	Bucket string
+	// Buckets maps 'database/retention policy' or 'database' of written lines to buckets in InfluxDB 2.x.
+	// Lines, which are not mapped, are written to bucket named 'database/retention policy', as created by influxd upgrade.
+	Buckets map[string]string
+	// Token is the API token used for authentication with InfluxDB 2.x.
+	Token string
+
+	// Precision is the precision of the written timestamps, one of 'ns', 'u', 'ms', 's', 'm' or 'h'.
+	// 'm' and 'h' are not supported by InfluxDB 2.x. Timestamps are truncated to it. Defaults to 'ns'.
+	Precision string
+	// BatchSize is the number of lines posted at once. Defaults to DefaultHTTPBatchSize.
+	BatchSize int
+	// Concurrency is the maximum number of concurrent requests. Defaults to DefaultHTTPConcurrency.
+	Concurrency int
+	// Gzip, if set, compresses the request bodies.
+	Gzip bool
+	// Retries is the number of times a request, which failed due to a network error,
+	// a server error or rate limiting, is retried. Defaults to DefaultHTTPRetries, negative value disables retries.
+	Retries int
+	// RetryInterval is the delay before the first retry, it doubles with each subsequent retry.
+	// Retry-After header of the response takes precedence. Defaults to DefaultHTTPRetryInterval.
+	RetryInterval time.Duration
+	// Client is the HTTP client to use. Defaults to http.DefaultClient.
+	Client *http.Client
+	// DeadLetter, if not nil, is where batches rejected by InfluxDB as invalid, e.g. due to a partial write
+	// or a field type conflict, are written to instead of failing, each preceded by a comment describing the error.
+	DeadLetter io.Writer
+}
+
+// dbrp is a database and retention policy pair, or a bucket in db.
+type dbrp struct {
+	db, rp string
+}
+
+// batch is a batch of lines.
+type batch struct {
+	buf bytes.Buffer
+	n   int
+}
+
+// HTTPWriter is a Writer, which posts batches of lines to InfluxDB.
+// Lines are batched per database and retention policy. The first failed request fails all subsequent calls.
+// HTTPWriter is not safe for concurrent use.
+type HTTPWriter struct {
+	conf HTTPConfig
+	unit int64
+
+	batches map[dbrp]*batch
+	line    []byte
+	sem     chan struct{}
+	wg      sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+	// rejected is the number of lines written to conf.DeadLetter.
+	rejected int64
+}
+
+// NewHTTPWriter returns a new HTTPWriter configured by conf.
+func NewHTTPWriter(conf HTTPConfig) (*HTTPWriter, error) {
+	if conf.URL == "" {
+		return nil, errors.New("URL must be specified")
+	}
+	if _, err := url.Parse(conf.URL); err != nil {
+		return nil, errors.Wrapf(err, "invalid URL '%s'", conf.URL)
+	}
+	if conf.Precision == "" {
+		conf.Precision = "ns"
+	}
+	unit, ok := precisions[conf.Precision]
+	if !ok {
+		return nil, errors.Errorf("unknown precision '%s', must be one of 'ns', 'u', 'ms', 's', 'm' or 'h'", conf.Precision)
+	}
+	if conf.Org != "" {
+		switch conf.Precision {
+		case "u":
+			conf.Precision = "us"
+		case "m", "h":
+			return nil, errors.Errorf("precision '%s' is not supported by InfluxDB 2.x", conf.Precision)
+		}
+		if conf.Token == "" {
+			return nil, errors.New("token must be specified")
+		}
+	} else if conf.Bucket != "" || len(conf.Buckets) > 0 {
+		return nil, errors.New("org must be specified to write to buckets")
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = DefaultHTTPBatchSize
+	}
+	if conf.Retries == 0 {
+		conf.Retries = DefaultHTTPRetries
+	}
+	if conf.RetryInterval <= 0 {
+		conf.RetryInterval = DefaultHTTPRetryInterval
+	}
+	if conf.Concurrency <= 0 {
+		conf.Concurrency = DefaultHTTPConcurrency
+	}
+	if conf.Client == nil {
+		conf.Client = http.DefaultClient
+	}
+	return &HTTPWriter{
+		conf:    conf,
+		unit:    unit,
+		batches: make(map[dbrp]*batch),
+		sem:     make(chan struct{}, conf.Concurrency),
+	}, nil
+}
+
+// convertPrecision appends line to b with the timestamp, which is in nanoseconds, converted to unit.
+func convertPrecision(b, line []byte, unit int64) []byte {
+	if unit == 1 {
+		return append(b, line...)
+	}
+	i := bytes.LastIndexByte(line, ' ')
+	if i < 0 {
+		return append(b, line...)
+	}
+	t, err := parseIntBytes(line[i+1:], 10, 64)
+	if err != nil {
+		return append(b, line...)
+	}
+	b = append(b, line[:i+1]...)
+	return strconv.AppendInt(b, t/unit, 10)
+}
+
+// WriteLine implements Writer.
+func (w *HTTPWriter) WriteLine(ctx context.Context, db, rp string, line []byte) error {
+	if err := w.firstError(); err != nil {
+		return err
+	}
+	if w.conf.Database != "" {
+		db = w.conf.Database
+	}
+	if w.conf.RetentionPolicy != "" {
+		rp = w.conf.RetentionPolicy
+	}
+	if db == "" {
+		return errors.New("database is not known, specify it explicitly")
+	}
+
+	k := dbrp{db: db, rp: rp}
+	if w.conf.Org != "" {
+		k = dbrp{db: w.bucket(db, rp)}
+	}
+	b, ok := w.batches[k]
+	if !ok {
+		b = &batch{}
+		w.batches[k] = b
+	}
+	w.line = append(convertPrecision(w.line[:0], line, w.unit), '\n')
+	b.buf.Write(w.line)
+	b.n++
+	if b.n < w.conf.BatchSize {
+		return nil
+	}
+	delete(w.batches, k)
+	return w.send(ctx, k, b)
+}
+
+// Flush implements Writer.
+func (w *HTTPWriter) Flush(ctx context.Context) error {
+	var err error
+	for k, b := range w.batches {
+		delete(w.batches, k)
+		if err = w.send(ctx, k, b); err != nil {
+			break
+		}
+	}
+	w.wg.Wait()
+	if ferr := w.firstError(); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+// Rejected returns the number of lines written to the dead-letter file.
+func (w *HTTPWriter) Rejected() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rejected
+}
+
+// deadLetter writes b, which was rejected with err, to conf.DeadLetter.
+func (w *HTTPWriter) deadLetter(k dbrp, b *batch, err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	target := fmt.Sprintf("database=%s retention-policy=%s", k.db, k.rp)
+	if w.conf.Org != "" {
+		target = fmt.Sprintf("org=%s bucket=%s", w.conf.Org, k.db)
+	}
+	if _, werr := fmt.Fprintf(w.conf.DeadLetter, "# %s precision=%s lines=%d error=%s\n", target, w.conf.Precision, b.n, strings.ReplaceAll(err.Error(), "\n", " ")); werr != nil {
+		return errors.Wrap(werr, "failed to write to dead-letter file")
+	}
+	if _, werr := w.conf.DeadLetter.Write(b.buf.Bytes()); werr != nil {
+		return errors.Wrap(werr, "failed to write to dead-letter file")
+	}
+	w.rejected += int64(b.n)
+	return nil
+}
+
+// firstError returns the error of the first failed request, if any.
+func (w *HTTPWriter) firstError() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// send posts b asynchronously, blocking while conf.Concurrency requests are in flight.
+func (w *HTTPWriter) send(ctx context.Context, k dbrp, b *batch) error {
+	select {
+	case w.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := w.firstError(); err != nil {
+		<-w.sem
+		return err
+	}
+	w.wg.Add(1)
+	go func() {
+		defer func() {
+			<-w.sem
+			w.wg.Done()
+		}()
+		err := w.post(ctx, k, b)
+		if err != nil && w.conf.DeadLetter != nil && rejected(err) {
+			err = w.deadLetter(k, b, err)
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if err != nil && w.err == nil {
+			w.err = errors.Wrapf(err, "failed to write %d lines to %s", b.n, k.db)
+		}
+	}()
+	return nil
+}
+
+// bucket returns the InfluxDB 2.x bucket for db and rp.
+func (w *HTTPWriter) bucket(db, rp string) string {
+	if w.conf.Bucket != "" {
+		return w.conf.Bucket
+	}
+	if b, ok := w.conf.Buckets[db+"/"+rp]; ok {
+		return b
+	}
+	if b, ok := w.conf.Buckets[db]; ok {
+		return b
+	}
+	if rp == "" {
+		rp = "autogen"
+	}
+	return db + "/" + rp
+}
+
+// endpoint returns the URL lines of k are posted to. For InfluxDB 2.x, k.db is the bucket.
+func (w *HTTPWriter) endpoint(k dbrp) string {
+	q := url.Values{}
+	q.Set("precision", w.conf.Precision)
+	if w.conf.Org != "" {
+		q.Set("org", w.conf.Org)
+		q.Set("bucket", k.db)
+		return strings.TrimSuffix(w.conf.URL, "/") + "/api/v2/write?" + q.Encode()
+	}
+	q.Set("db", k.db)
+	if k.rp != "" {
+		q.Set("rp", k.rp)
+	}
+	return strings.TrimSuffix(w.conf.URL, "/") + "/write?" + q.Encode()
+}
+
+// post posts b, retrying on transient failures.
+func (w *HTTPWriter) post(ctx context.Context, k dbrp, b *batch) error {
+	body := b.buf.Bytes()
+	if w.conf.Gzip {
+		zb := &bytes.Buffer{}
+		zw := gzip.NewWriter(zb)
+		if _, err := zw.Write(body); err != nil {
+			return errors.Wrap(err, "failed to compress request body")
+		}
+		if err := zw.Close(); err != nil {
+			return errors.Wrap(err, "failed to compress request body")
+		}
+		body = zb.Bytes()
+	}
+
+	interval := w.conf.RetryInterval
+	for i := 0; ; i++ {
+		err := w.do(ctx, w.endpoint(k), body)
+		if err == nil || i >= w.conf.Retries || !retryable(err) {
+			return err
+		}
+
+		delay := interval
+		if herr, ok := err.(*httpError); ok && herr.retryAfter > 0 {
+			delay = herr.retryAfter
+		}
+		if interval *= 2; interval > maxRetryInterval {
+			interval = maxRetryInterval
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
+
+// do posts body to u once.
+func (w *HTTPWriter) do(ctx context.Context, u string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.conf.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	switch {
+	case w.conf.Org != "":
+		req.Header.Set("Authorization", "Token "+w.conf.Token)
+	case w.conf.Username != "":
+		req.SetBasicAuth(w.conf.Username, w.conf.Password)
+	}
+
+	resp, err := w.conf.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+// httpError is an error response of InfluxDB.
+type httpError struct {
+	status     string
+	code       int
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *httpError) Error() string {
+	if e.message == "" {
+		return e.status
+	}
+	return e.status + ": " + e.message
+}
+
+// retryable reports whether a request, which failed with err, may be retried.
+func retryable(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	if herr, ok := err.(*httpError); ok {
+		return herr.code == http.StatusTooManyRequests || herr.code >= 500 && herr.code != http.StatusNotImplemented
+	}
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return !errors.Is(uerr.Err, context.Canceled) && !errors.Is(uerr.Err, context.DeadlineExceeded)
+	}
+	return false
+}
+
+// rejected reports whether a request failed with err, because InfluxDB rejected the data as invalid.
+func rejected(err error) bool {
+	herr, ok := err.(*httpError)
+	if !ok {
+		return false
+	}
+	switch herr.code {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// checkResponse returns an error of type *httpError describing resp, if its status does not indicate success.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	herr := &httpError{
+		status: resp.Status,
+		code:   resp.StatusCode,
+	}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		herr.retryAfter = time.Duration(s) * time.Second
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLength))
+	var body struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	switch {
+	case json.Unmarshal(b, &body) == nil && body.Error != "":
+		herr.message = body.Error
+	case body.Message != "":
+		herr.message = body.Message
+	default:
+		herr.message = strings.TrimSpace(string(b))
+	}
+	return herr
+}
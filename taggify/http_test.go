@@ -0,0 +1,300 @@
+package taggify
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeInflux is a fake InfluxDB HTTP API, which records written lines.
+type fakeInflux struct {
+	mu       sync.Mutex
+	lines    map[string][]string
+	requests int
+	inFlight int
+	maxIn    int
+	gzipped  int
+	delay    time.Duration
+	status   int
+	// failures is the number of requests to fail with 503 before succeeding.
+	failures int
+	// failAfter, if positive, is the number of requests, after which all requests fail with 500.
+	failAfter int
+	// reject, if not nil, reports whether a body is rejected with 400.
+	reject func(body string) bool
+}
+
+func newFakeInflux() *fakeInflux {
+	return &fakeInflux{lines: make(map[string][]string)}
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests++
+	f.inFlight++
+	if f.inFlight > f.maxIn {
+		f.maxIn = f.inFlight
+	}
+	status := f.status
+	if f.failures > 0 {
+		f.failures--
+		status = http.StatusServiceUnavailable
+	}
+	if f.failAfter > 0 && f.requests > f.failAfter {
+		status = http.StatusInternalServerError
+	}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+	time.Sleep(f.delay)
+
+	if status != 0 {
+		w.Header().Set("Content-Type", "application/json")
+		switch status {
+		case http.StatusServiceUnavailable:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(status)
+			io.WriteString(w, `{"code":"unavailable","message":"overloaded"}`)
+		case http.StatusInternalServerError:
+			w.WriteHeader(status)
+			io.WriteString(w, `{"error":"engine: shutting down"}`)
+		default:
+			w.WriteHeader(status)
+			io.WriteString(w, `{"error":"database not found: \"test\""}`)
+		}
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = zr
+		f.mu.Lock()
+		f.gzipped++
+		f.mu.Unlock()
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.reject != nil && f.reject(string(b)) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":"partial write: field type conflict: input field \"value\" on measurement \"test\" is type string, already exists as type float dropped=1"}`)
+		return
+	}
+	q := r.URL.Query()
+	k := q.Get("db") + "/" + q.Get("rp") + "/" + q.Get("precision")
+	if r.URL.Path == "/api/v2/write" {
+		if r.Header.Get("Authorization") != "Token secret" {
+			http.Error(w, `{"code":"unauthorized","message":"unauthorized access"}`, http.StatusUnauthorized)
+			return
+		}
+		k = q.Get("org") + ":" + q.Get("bucket") + ":" + q.Get("precision")
+	}
+	f.mu.Lock()
+	f.lines[k] = append(f.lines[k], strings.Split(strings.TrimSpace(string(b)), "\n")...)
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeInflux) sorted(k string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines := append([]string(nil), f.lines[k]...)
+	sort.Strings(lines)
+	return lines
+}
+
+func TestHTTPWriter(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=42 1511629912071663075
+test,id=foo int=43 1511629912071663076
+test,id=bar int=44 1511629912071663077`
+	wal := `test,id=wal int=45 1511629912071663078
+# CONTEXT-DATABASE:other
+# CONTEXT-RETENTION-POLICY:rp2
+# writing tsm data
+other value=2 1511629912071663079`
+	input := strings.Join([]string{header, data, footer, wal}, string('\n'))
+
+	fake := newFakeInflux()
+	fake.delay = 10 * time.Millisecond
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	w, err := NewHTTPWriter(HTTPConfig{
+		URL:         srv.URL,
+		BatchSize:   1,
+		Concurrency: 2,
+		Gzip:        true,
+	})
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	_, err = Transform(context.Background(), strings.NewReader(input), nil, Options{
+		Fields: []string{"idd"},
+		Writer: w,
+	})
+	a.NoError(err)
+	a.Equal([]string{
+		"test,id=bar int=44 1511629912071663077",
+		"test,id=foo int=43 1511629912071663076",
+		"test,id=foo,idd=bar int=42 1511629912071663075",
+		"test,id=wal int=45 1511629912071663078",
+	}, fake.sorted("test/autogen/ns"))
+	a.Equal([]string{
+		"other value=2 1511629912071663079",
+	}, fake.sorted("other/rp2/ns"))
+	a.Equal(5, fake.requests)
+	a.Equal(5, fake.gzipped)
+	a.Equal(2, fake.maxIn)
+
+	fake = newFakeInflux()
+	srv = httptest.NewServer(fake)
+	defer srv.Close()
+	w, err = NewHTTPWriter(HTTPConfig{
+		URL:             srv.URL,
+		Database:        "override",
+		RetentionPolicy: "rp",
+		Precision:       "s",
+	})
+	a.NoError(err)
+	_, err = Transform(context.Background(), strings.NewReader(input), nil, Options{
+		Fields: []string{"idd"},
+		Writer: w,
+	})
+	a.NoError(err)
+	a.Equal([]string{
+		"other value=2 1511629912",
+		"test,id=bar int=44 1511629912",
+		"test,id=foo int=43 1511629912",
+		"test,id=foo,idd=bar int=42 1511629912",
+		"test,id=wal int=45 1511629912",
+	}, fake.sorted("override/rp/s"))
+	a.Equal(1, fake.requests)
+
+	fake = newFakeInflux()
+	fake.status = http.StatusNotFound
+	srv = httptest.NewServer(fake)
+	defer srv.Close()
+	w, err = NewHTTPWriter(HTTPConfig{URL: srv.URL})
+	a.NoError(err)
+	_, err = Transform(context.Background(), strings.NewReader(input), nil, Options{
+		Writer: w,
+	})
+	if a.Error(err) {
+		a.Contains(err.Error(), `404 Not Found: database not found: "test"`)
+	}
+
+	_, err = NewHTTPWriter(HTTPConfig{URL: srv.URL, Precision: "d"})
+	a.Error(err)
+}
+
+func TestHTTPWriterV2(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=42 1511629912071663075`
+	wal := `# CONTEXT-DATABASE:other
+# CONTEXT-RETENTION-POLICY:rp2
+other value=2 1511629912071663079
+# CONTEXT-DATABASE:mapped
+# CONTEXT-RETENTION-POLICY:autogen
+mapped value=3 1511629912071663080`
+	input := strings.Join([]string{header, data, footer, wal}, string('\n'))
+
+	fake := newFakeInflux()
+	fake.failures = 2
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	w, err := NewHTTPWriter(HTTPConfig{
+		URL:       srv.URL,
+		Org:       "org",
+		Token:     "secret",
+		Precision: "u",
+		Buckets: map[string]string{
+			"test/autogen": "test-bucket",
+			"mapped":       "mapped-bucket",
+		},
+		Concurrency:   1,
+		RetryInterval: time.Millisecond,
+	})
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	_, err = Transform(context.Background(), strings.NewReader(input), nil, Options{
+		Fields: []string{"idd"},
+		Writer: w,
+	})
+	a.NoError(err)
+	a.Equal([]string{"test,id=foo,idd=bar int=42 1511629912071663"}, fake.sorted("org:test-bucket:us"))
+	a.Equal([]string{"other value=2 1511629912071663"}, fake.sorted("org:other/rp2:us"))
+	a.Equal([]string{"mapped value=3 1511629912071663"}, fake.sorted("org:mapped-bucket:us"))
+	a.Equal(5, fake.requests)
+
+	fake = newFakeInflux()
+	fake.failures = 3
+	srv = httptest.NewServer(fake)
+	defer srv.Close()
+	w, err = NewHTTPWriter(HTTPConfig{
+		URL:           srv.URL,
+		Org:           "org",
+		Bucket:        "bucket",
+		Token:         "secret",
+		Concurrency:   1,
+		Retries:       2,
+		RetryInterval: time.Millisecond,
+	})
+	a.NoError(err)
+	_, err = Transform(context.Background(), strings.NewReader(input), nil, Options{
+		Writer: w,
+	})
+	if a.Error(err) {
+		a.Contains(err.Error(), "503 Service Unavailable: overloaded")
+	}
+	a.Equal(3, fake.requests)
+
+	w, err = NewHTTPWriter(HTTPConfig{
+		URL:     srv.URL,
+		Org:     "org",
+		Bucket:  "bucket",
+		Token:   "wrong",
+		Retries: -1,
+	})
+	a.NoError(err)
+	_, err = Transform(context.Background(), strings.NewReader(input), nil, Options{
+		Writer: w,
+	})
+	if a.Error(err) {
+		a.Contains(err.Error(), "401 Unauthorized: unauthorized access")
+	}
+
+	_, err = NewHTTPWriter(HTTPConfig{URL: srv.URL, Org: "org", Token: "secret", Precision: "h"})
+	a.Error(err)
+	_, err = NewHTTPWriter(HTTPConfig{URL: srv.URL, Org: "org"})
+	a.Error(err)
+	_, err = NewHTTPWriter(HTTPConfig{URL: srv.URL, Bucket: "bucket"})
+	a.Error(err)
+}
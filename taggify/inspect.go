@@ -0,0 +1,309 @@
+package taggify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxValues is the default number of distinct values tracked by Inspect per tag key, field or measurement.
+const DefaultMaxValues = 100000
+
+// InspectConfig configures Inspect.
+type InspectConfig struct {
+	// MaxValues is the number of distinct values tracked per tag key, string field and series of a measurement,
+	// DefaultMaxValues if zero. Cardinalities above it are reported as truncated, which bounds memory usage.
+	MaxValues int
+}
+
+// Cardinality is a number of distinct values.
+type Cardinality struct {
+	Count int `json:"count"`
+	// Truncated indicates that tracking stopped at Count, so the actual cardinality is higher.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func (c Cardinality) String() string {
+	if c.Truncated {
+		return fmt.Sprintf("more than %d", c.Count)
+	}
+	return fmt.Sprint(c.Count)
+}
+
+// TagSchema describes a tag key of a measurement.
+type TagSchema struct {
+	Key    string      `json:"key"`
+	Values Cardinality `json:"values"`
+}
+
+// FieldSchema describes a field of a measurement.
+type FieldSchema struct {
+	Key string `json:"key"`
+	// Types are the types of the field, more than one if its type differs between shards.
+	Types []string `json:"types"`
+	// Points is the number of points containing the field.
+	Points int64 `json:"points"`
+	// Values is the cardinality of the values of a string field.
+	Values *Cardinality `json:"values,omitempty"`
+}
+
+// MeasurementSchema describes a measurement of a retention policy.
+type MeasurementSchema struct {
+	Database        string        `json:"database"`
+	RetentionPolicy string        `json:"retention_policy"`
+	Name            string        `json:"name"`
+	Points          int64         `json:"points"`
+	Series          Cardinality   `json:"series"`
+	Start           time.Time     `json:"start"`
+	End             time.Time     `json:"end"`
+	Tags            []TagSchema   `json:"tags"`
+	Fields          []FieldSchema `json:"fields"`
+}
+
+// Schema is the schema of an export discovered by Inspect.
+type Schema struct {
+	Measurements []MeasurementSchema `json:"measurements"`
+}
+
+// valueSet is a set of up to max distinct values.
+type valueSet struct {
+	values    map[string]struct{}
+	max       int
+	truncated bool
+}
+
+func newValueSet(max int) *valueSet {
+	return &valueSet{values: make(map[string]struct{}), max: max}
+}
+
+func (s *valueSet) add(v string) {
+	if _, ok := s.values[v]; ok || s.truncated {
+		return
+	}
+	if len(s.values) == s.max {
+		s.truncated = true
+		return
+	}
+	s.values[v] = struct{}{}
+}
+
+func (s *valueSet) cardinality() Cardinality {
+	return Cardinality{Count: len(s.values), Truncated: s.truncated}
+}
+
+// fieldStats are the statistics of a field gathered by Inspect.
+type fieldStats struct {
+	types  map[string]struct{}
+	points int64
+	values *valueSet
+	// tagLike is gathered only by Suggest.
+	tagLike *tagLikeStats
+}
+
+// sampleKey identifies a measurement of a retention policy.
+type sampleKey struct {
+	dbrp
+	measurement string
+}
+
+// sortSampleKeys sorts keys by database, retention policy and measurement.
+func sortSampleKeys(keys []sampleKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].db != keys[j].db {
+			return keys[i].db < keys[j].db
+		}
+		if keys[i].rp != keys[j].rp {
+			return keys[i].rp < keys[j].rp
+		}
+		return keys[i].measurement < keys[j].measurement
+	})
+}
+
+// measurementStats are the statistics of a measurement gathered by Inspect.
+type measurementStats struct {
+	points   int64
+	series   *valueSet
+	min, max int64
+	tags     map[string]*valueSet
+	fields   map[string]*fieldStats
+	// suggest enables gathering of statistics needed by Suggest.
+	suggest bool
+}
+
+func (ms *measurementStats) add(p *Point, maxValues int) {
+	ms.points++
+	key := p.SeriesKey()
+	ms.series.add(key)
+	if p.Time < ms.min {
+		ms.min = p.Time
+	}
+	if p.Time > ms.max {
+		ms.max = p.Time
+	}
+	for _, t := range p.Tags {
+		vs, ok := ms.tags[t.Key]
+		if !ok {
+			vs = newValueSet(maxValues)
+			ms.tags[t.Key] = vs
+		}
+		vs.add(t.Value)
+	}
+	for _, f := range p.Fields {
+		fs, ok := ms.fields[f.Key]
+		if !ok {
+			fs = &fieldStats{types: make(map[string]struct{})}
+			ms.fields[f.Key] = fs
+		}
+		fs.points++
+		fs.types[fieldType(f.Value)] = struct{}{}
+		if s, ok := f.Value.(string); ok {
+			if fs.values == nil {
+				fs.values = newValueSet(maxValues)
+			}
+			fs.values.add(s)
+		}
+		if ms.suggest {
+			if fs.tagLike == nil {
+				fs.tagLike = newTagLikeStats(maxValues)
+			}
+			fs.tagLike.add(key, FormatValue(f.Value))
+		}
+	}
+}
+
+func (ms *measurementStats) schema(k sampleKey) MeasurementSchema {
+	s := MeasurementSchema{
+		Database:        k.db,
+		RetentionPolicy: k.rp,
+		Name:            k.measurement,
+		Points:          ms.points,
+		Series:          ms.series.cardinality(),
+		Start:           time.Unix(0, ms.min).UTC(),
+		End:             time.Unix(0, ms.max).UTC(),
+		Tags:            make([]TagSchema, 0, len(ms.tags)),
+		Fields:          make([]FieldSchema, 0, len(ms.fields)),
+	}
+	for key, vs := range ms.tags {
+		s.Tags = append(s.Tags, TagSchema{Key: key, Values: vs.cardinality()})
+	}
+	sort.Slice(s.Tags, func(i, j int) bool { return s.Tags[i].Key < s.Tags[j].Key })
+	for key, fs := range ms.fields {
+		f := FieldSchema{Key: key, Points: fs.points}
+		for t := range fs.types {
+			f.Types = append(f.Types, t)
+		}
+		sort.Strings(f.Types)
+		if fs.values != nil {
+			c := fs.values.cardinality()
+			f.Values = &c
+		}
+		s.Fields = append(s.Fields, f)
+	}
+	sort.Slice(s.Fields, func(i, j int) bool { return s.Fields[i].Key < s.Fields[j].Key })
+	return s
+}
+
+// inspector gathers statistics of measurements.
+type inspector struct {
+	conf         InspectConfig
+	suggest      bool
+	measurements map[sampleKey]*measurementStats
+}
+
+func newInspector(conf InspectConfig) *inspector {
+	if conf.MaxValues <= 0 {
+		conf.MaxValues = DefaultMaxValues
+	}
+	return &inspector{
+		conf:         conf,
+		measurements: make(map[sampleKey]*measurementStats),
+	}
+}
+
+// measurement returns the statistics of measurement m in retention policy rp of database db.
+func (in *inspector) measurement(db, rp, m string) *measurementStats {
+	k := sampleKey{dbrp: dbrp{db: db, rp: rp}, measurement: m}
+	ms, ok := in.measurements[k]
+	if !ok {
+		ms = &measurementStats{
+			series:  newValueSet(in.conf.MaxValues),
+			min:     math.MaxInt64,
+			max:     math.MinInt64,
+			tags:    make(map[string]*valueSet),
+			fields:  make(map[string]*fieldStats),
+			suggest: in.suggest,
+		}
+		in.measurements[k] = ms
+	}
+	return ms
+}
+
+// keys returns the keys of the inspected measurements sorted by database, retention policy and name.
+func (in *inspector) keys() []sampleKey {
+	keys := make([]sampleKey, 0, len(in.measurements))
+	for k := range in.measurements {
+		keys = append(keys, k)
+	}
+	sortSampleKeys(keys)
+	return keys
+}
+
+// Inspect reads the export or line protocol in r and returns its schema.
+// The data is streamed, memory usage is bounded by the number of measurements, keys and conf.MaxValues.
+func Inspect(ctx context.Context, r io.Reader, conf InspectConfig) (*Schema, error) {
+	in := newInspector(conf)
+	if err := readExport(ctx, r, func(db, rp string, p *Point) error {
+		in.measurement(db, rp, p.Measurement).add(p, in.conf.MaxValues)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to read input")
+	}
+	s := &Schema{Measurements: make([]MeasurementSchema, 0, len(in.measurements))}
+	for _, k := range in.keys() {
+		s.Measurements = append(s.Measurements, in.measurements[k].schema(k))
+	}
+	return s, nil
+}
+
+// WriteSchema writes s to w in specified format, which is either "text" or "json".
+func WriteSchema(w io.Writer, s *Schema, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(s)
+	case "text", "":
+	default:
+		return errors.Errorf("unknown schema format '%s'", format)
+	}
+
+	var b strings.Builder
+	for _, m := range s.Measurements {
+		if m.Database != "" {
+			fmt.Fprintf(&b, "Database %s, retention policy %s, measurement %s\n", m.Database, m.RetentionPolicy, m.Name)
+		} else {
+			fmt.Fprintf(&b, "Measurement %s\n", m.Name)
+		}
+		fmt.Fprintf(&b, "\tPoints: %d, series: %s\n", m.Points, m.Series)
+		fmt.Fprintf(&b, "\tTime range: %s - %s\n", m.Start.Format(time.RFC3339Nano), m.End.Format(time.RFC3339Nano))
+		for _, t := range m.Tags {
+			fmt.Fprintf(&b, "\tTag %s: %s values\n", t.Key, t.Values)
+		}
+		for _, f := range m.Fields {
+			fmt.Fprintf(&b, "\tField %s: %s, %d points", f.Key, strings.Join(f.Types, ", "), f.Points)
+			if f.Values != nil {
+				fmt.Fprintf(&b, ", %s distinct values", f.Values)
+			}
+			b.WriteByte('\n')
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,110 @@
+package taggify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspect(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=42i 1511629912071663075
+test,id=bar idd="baz" 1511629912071663076
+test,id=baz,host=a idd="baz" 1511629912071663077
+cpu,host=a usage=0.5 1511629912071663075`
+	wal := `test,id=qux int=43 1511629912071663078
+# CONTEXT-DATABASE:other
+# CONTEXT-RETENTION-POLICY:rp
+test value=true 1511629912071663079`
+	input := strings.Join([]string{header, data, footer, wal}, "\n")
+
+	s, err := Inspect(context.Background(), strings.NewReader(input), InspectConfig{MaxValues: 2})
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Equal(&Schema{Measurements: []MeasurementSchema{
+		{
+			Database:        "other",
+			RetentionPolicy: "rp",
+			Name:            "test",
+			Points:          1,
+			Series:          Cardinality{Count: 1},
+			Start:           time.Unix(0, 1511629912071663079).UTC(),
+			End:             time.Unix(0, 1511629912071663079).UTC(),
+			Tags:            []TagSchema{},
+			Fields:          []FieldSchema{{Key: "value", Types: []string{"boolean"}, Points: 1}},
+		},
+		{
+			Database:        "test",
+			RetentionPolicy: "autogen",
+			Name:            "cpu",
+			Points:          1,
+			Series:          Cardinality{Count: 1},
+			Start:           time.Unix(0, 1511629912071663075).UTC(),
+			End:             time.Unix(0, 1511629912071663075).UTC(),
+			Tags:            []TagSchema{{Key: "host", Values: Cardinality{Count: 1}}},
+			Fields:          []FieldSchema{{Key: "usage", Types: []string{"float"}, Points: 1}},
+		},
+		{
+			Database:        "test",
+			RetentionPolicy: "autogen",
+			Name:            "test",
+			Points:          5,
+			Series:          Cardinality{Count: 2, Truncated: true},
+			Start:           time.Unix(0, 1511629912071663075).UTC(),
+			End:             time.Unix(0, 1511629912071663078).UTC(),
+			Tags: []TagSchema{
+				{Key: "host", Values: Cardinality{Count: 1}},
+				{Key: "id", Values: Cardinality{Count: 2, Truncated: true}},
+			},
+			Fields: []FieldSchema{
+				{Key: "idd", Types: []string{"string"}, Points: 3, Values: &Cardinality{Count: 2}},
+				{Key: "int", Types: []string{"float", "integer"}, Points: 2},
+			},
+		},
+	}}, s)
+
+	out := &bytes.Buffer{}
+	a.NoError(WriteSchema(out, s, "text"))
+	a.Equal(`Database other, retention policy rp, measurement test
+	Points: 1, series: 1
+	Time range: 2017-11-25T17:11:52.071663079Z - 2017-11-25T17:11:52.071663079Z
+	Field value: boolean, 1 points
+Database test, retention policy autogen, measurement cpu
+	Points: 1, series: 1
+	Time range: 2017-11-25T17:11:52.071663075Z - 2017-11-25T17:11:52.071663075Z
+	Tag host: 1 values
+	Field usage: float, 1 points
+Database test, retention policy autogen, measurement test
+	Points: 5, series: more than 2
+	Time range: 2017-11-25T17:11:52.071663075Z - 2017-11-25T17:11:52.071663078Z
+	Tag host: 1 values
+	Tag id: more than 2 values
+	Field idd: string, 3 points, 2 distinct values
+	Field int: float, integer, 2 points
+`, out.String())
+
+	out.Reset()
+	a.NoError(WriteSchema(out, s, "json"))
+	var decoded Schema
+	a.NoError(json.Unmarshal(out.Bytes(), &decoded))
+	a.Equal(s, &decoded)
+	a.Error(WriteSchema(out, s, "yaml"))
+
+	s, err = Inspect(context.Background(), strings.NewReader("plain value=1 1\n"), InspectConfig{})
+	a.NoError(err)
+	if a.Len(s.Measurements, 1) {
+		a.Equal("", s.Measurements[0].Database)
+		a.Equal(int64(1), s.Measurements[0].Points)
+	}
+
+	_, err = Inspect(context.Background(), strings.NewReader("plain value=\n"), InspectConfig{})
+	a.Error(err)
+}
@@ -0,0 +1,125 @@
+package taggify
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLineTooLong is returned when a line exceeds the configured maximum length.
+var ErrLineTooLong = errors.New("line too long")
+
+// lineReader reads lines of arbitrary length from an io.Reader.
+// Its API mirrors the one of bufio.Scanner.
+type lineReader struct {
+	r *bufio.Reader
+	// max is the maximum length of a line. Zero means no limit.
+	max int
+
+	line []byte
+	err  error
+
+	// n is the 1-based number of the current line.
+	n int
+	// offset is the byte offset of the current line, next is the byte offset of the next line.
+	offset, next int64
+	// tooLong indicates that the current line exceeded max and was truncated.
+	tooLong bool
+	// newline indicates that the current line was terminated by a newline.
+	newline bool
+}
+
+func newLineReader(r io.Reader, max int) *lineReader {
+	return &lineReader{
+		r:   bufio.NewReader(r),
+		max: max,
+	}
+}
+
+// Scan advances lr to the next line. It returns false when input is exhausted or an error occurs.
+// Lines longer than lr.max are truncated and reported by tooLong, the remainder is discarded.
+func (lr *lineReader) Scan() bool {
+	if lr.err != nil {
+		return false
+	}
+	lr.line = lr.line[:0]
+	lr.tooLong = false
+	lr.newline = false
+	lr.offset = lr.next
+
+	var read int64
+	var last []byte
+	for {
+		b, err := lr.r.ReadSlice('\n')
+		read += int64(len(b))
+		last = b
+		if !lr.tooLong {
+			lr.line = append(lr.line, b...)
+			// allow for the line terminator, which is checked below
+			if lr.max > 0 && len(lr.line) > lr.max+2 {
+				lr.line = lr.line[:lr.max]
+				lr.tooLong = true
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		lr.next += read
+		if err != nil {
+			if err != io.EOF {
+				lr.err = err
+				return false
+			}
+			lr.err = io.EOF
+			if read == 0 {
+				return false
+			}
+		}
+		break
+	}
+	lr.newline = len(last) > 0 && last[len(last)-1] == '\n'
+	if !lr.tooLong {
+		lr.line = bytes.TrimSuffix(lr.line, []byte{'\n'})
+		lr.line = bytes.TrimSuffix(lr.line, []byte{'\r'})
+		if lr.max > 0 && len(lr.line) > lr.max {
+			lr.line = lr.line[:lr.max]
+			lr.tooLong = true
+		}
+	}
+	lr.n++
+	return true
+}
+
+// Text returns the current line without the line terminator.
+func (lr *lineReader) Text() string {
+	return string(lr.line)
+}
+
+// Bytes returns the current line without the line terminator.
+// The underlying array may be overwritten by a subsequent call to Scan.
+func (lr *lineReader) Bytes() []byte {
+	return lr.line
+}
+
+// Err returns the first non-EOF error encountered.
+func (lr *lineReader) Err() error {
+	if lr.err == io.EOF {
+		return nil
+	}
+	return lr.err
+}
+
+// lineError returns the *ParseError describing a line, which is too long.
+func (lr *lineReader) lineError(s Section) *ParseError {
+	return &ParseError{
+		Section: s.String(),
+		Line:    lr.n,
+		Offset:  lr.offset,
+		Column:  lr.max + 1,
+		Excerpt: excerpt(lr.line, len(lr.line)),
+		Err:     fmt.Errorf("%w, exceeds %d bytes", ErrLineTooLong, lr.max),
+	}
+}
@@ -0,0 +1,54 @@
+package taggify
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineReader(t *testing.T) {
+	a := assert.New(t)
+
+	long := strings.Repeat("x", 1<<20)
+	lr := newLineReader(strings.NewReader("a\r\n"+long+"\nabcdef\nb"), 0)
+	for _, expected := range []struct {
+		line    string
+		offset  int64
+		newline bool
+	}{
+		{"a", 0, true},
+		{long, 3, true},
+		{"abcdef", int64(len(long)) + 4, true},
+		{"b", int64(len(long)) + 11, false},
+	} {
+		if !a.True(lr.Scan()) {
+			t.FailNow()
+		}
+		a.Equal(expected.line, lr.Text())
+		a.Equal(expected.offset, lr.offset)
+		a.Equal(expected.newline, lr.newline)
+		a.False(lr.tooLong)
+	}
+	a.False(lr.Scan())
+	a.NoError(lr.Err())
+
+	lr = newLineReader(strings.NewReader("a\r\n"+long+"\nabcdef\nabcde\n"), 5)
+	for _, expected := range []struct {
+		line    string
+		tooLong bool
+	}{
+		{"a", false},
+		{"xxxxx", true},
+		{"abcde", true},
+		{"abcde", false},
+	} {
+		if !a.True(lr.Scan()) {
+			t.FailNow()
+		}
+		a.Equal(expected.line, lr.Text())
+		a.Equal(expected.tooLong, lr.tooLong)
+	}
+	a.False(lr.Scan())
+	a.NoError(lr.Err())
+}
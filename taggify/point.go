@@ -0,0 +1,368 @@
+package taggify
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/pkg/errors"
+)
+
+// Tag is a tag of a point.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Field is a field of a point.
+// Value is one of int64, uint64, float64, bool or string.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Point is a row of line protocol. All names and values are unescaped.
+type Point struct {
+	Measurement string
+	// Tags are the tags of the point, sorted by key if the point was parsed.
+	Tags []Tag
+	// Fields are the fields of the point in order of appearance.
+	Fields []Field
+	// Time is the timestamp of the point in nanoseconds.
+	Time int64
+}
+
+// Tag returns the value of tag with key.
+func (p *Point) Tag(key string) (string, bool) {
+	for _, t := range p.Tags {
+		if t.Key == key {
+			return t.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetTag sets the value of tag with key, keeping the tags sorted by key.
+func (p *Point) SetTag(key, value string) {
+	i := sort.Search(len(p.Tags), func(i int) bool { return p.Tags[i].Key >= key })
+	if i < len(p.Tags) && p.Tags[i].Key == key {
+		p.Tags[i].Value = value
+		return
+	}
+	p.Tags = append(p.Tags, Tag{})
+	copy(p.Tags[i+1:], p.Tags[i:])
+	p.Tags[i] = Tag{Key: key, Value: value}
+}
+
+// DeleteTag deletes the tag with key.
+func (p *Point) DeleteTag(key string) {
+	for i, t := range p.Tags {
+		if t.Key == key {
+			p.Tags = append(p.Tags[:i], p.Tags[i+1:]...)
+			return
+		}
+	}
+}
+
+// Field returns the value of field with key.
+func (p *Point) Field(key string) (interface{}, bool) {
+	for _, f := range p.Fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// SetField sets the value of field with key. New fields are appended.
+func (p *Point) SetField(key string, value interface{}) {
+	for i, f := range p.Fields {
+		if f.Key == key {
+			p.Fields[i].Value = value
+			return
+		}
+	}
+	p.Fields = append(p.Fields, Field{Key: key, Value: value})
+}
+
+// DeleteField deletes the field with key.
+func (p *Point) DeleteField(key string) {
+	for i, f := range p.Fields {
+		if f.Key == key {
+			p.Fields = append(p.Fields[:i], p.Fields[i+1:]...)
+			return
+		}
+	}
+}
+
+// Copy returns a deep copy of p.
+func (p *Point) Copy() *Point {
+	return &Point{
+		Measurement: p.Measurement,
+		Tags:        append([]Tag(nil), p.Tags...),
+		Fields:      append([]Field(nil), p.Fields...),
+		Time:        p.Time,
+	}
+}
+
+// SeriesKey returns the escaped series key of p, i.e. measurement[,tag1=value1,tag2=value2...]
+// with tags sorted by key.
+func (p *Point) SeriesKey() string {
+	tags := p.Tags
+	if !sort.SliceIsSorted(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key }) {
+		tags = append([]Tag(nil), tags...)
+		sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+	}
+	b := appendEscaped(nil, p.Measurement, measurementEscapes)
+	for _, t := range tags {
+		b = append(b, ',')
+		b = appendEscaped(b, t.Key, tagEscapes)
+		b = append(b, '=')
+		b = appendEscaped(b, t.Value, tagEscapes)
+	}
+	return string(b)
+}
+
+// AppendLine appends the line protocol representation of p without the trailing newline to b.
+func (p *Point) AppendLine(b []byte) []byte {
+	b = append(b, p.SeriesKey()...)
+	b = append(b, ' ')
+	b = appendFields(b, p.Fields)
+	b = append(b, ' ')
+	return strconv.AppendInt(b, p.Time, 10)
+}
+
+// String returns the line protocol representation of p.
+func (p *Point) String() string {
+	return string(p.AppendLine(nil))
+}
+
+const (
+	measurementEscapes = ", "
+	tagEscapes         = ",= "
+	stringFieldEscapes = `"\`
+)
+
+// appendEscaped appends s to b, escaping characters in chars with a backslash.
+func appendEscaped(b []byte, s string, chars string) []byte {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(chars, s[i]) >= 0 {
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	return b
+}
+
+// unescape returns b with the backslashes preceding characters in chars removed.
+func unescape(b []byte, chars string) string {
+	if bytes.IndexByte(b, '\\') == -1 {
+		return string(b)
+	}
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] == '\\' && i+1 < len(b) && strings.IndexByte(chars, b[i+1]) >= 0 {
+			i++
+		}
+		out = append(out, b[i])
+	}
+	return string(out)
+}
+
+// FormatValue returns the representation of a field value v used when it is converted to a tag value.
+func FormatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// appendValue appends the line protocol representation of field value v to b.
+func appendValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case float64:
+		return strconv.AppendFloat(b, v, 'f', -1, 64)
+	case int64:
+		return append(strconv.AppendInt(b, v, 10), 'i')
+	case uint64:
+		return append(strconv.AppendUint(b, v, 10), 'u')
+	case bool:
+		return strconv.AppendBool(b, v)
+	case string:
+		b = append(b, '"')
+		b = appendEscaped(b, v, stringFieldEscapes)
+		return append(b, '"')
+	}
+	return b
+}
+
+func appendFields(b []byte, fields []Field) []byte {
+	for i, f := range fields {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendEscaped(b, f.Key, tagEscapes)
+		b = append(b, '=')
+		b = appendValue(b, f.Value)
+	}
+	return b
+}
+
+// parseKey parses the series key as returned by scanKey into measurement and tags.
+func parseKey(key []byte) (string, []Tag) {
+	i, m := scanTo(key, 0, ',')
+	measurement := unescape(m, measurementEscapes)
+
+	var tags []Tag
+	for i < len(key) {
+		var k, v []byte
+		i, k = scanTo(key, i+1, '=')
+		i, v = scanTo(key, i+1, ',')
+		tags = append(tags, Tag{
+			Key:   unescape(k, tagEscapes),
+			Value: unescape(v, tagEscapes),
+		})
+	}
+	return measurement, tags
+}
+
+// parseFieldValue parses a single unquoted or quoted field value as validated by scanFields.
+func parseFieldValue(b []byte) (interface{}, error) {
+	if len(b) == 0 {
+		return nil, errors.New("missing field value")
+	}
+	switch b[0] {
+	case '"':
+		return unescape(b[1:len(b)-1], stringFieldEscapes), nil
+	case 't', 'T', 'f', 'F':
+		return parseBoolBytes(b)
+	}
+	switch b[len(b)-1] {
+	case 'i':
+		return parseIntBytes(b[:len(b)-1], 10, 64)
+	case 'u':
+		return parseUintBytes(b[:len(b)-1], 10, 64)
+	}
+	return parseFloatBytes(b, 64)
+}
+
+// parseFields parses the fields section of a point as returned by scanFields.
+// It returns the fields and the offset within b, at which an error occurred.
+func parseFields(b []byte) ([]Field, int, error) {
+	var fields []Field
+	i := 0
+	for i < len(b) {
+		start := i
+		var k []byte
+		i, k = scanTo(b, i, '=')
+		if i >= len(b) {
+			return nil, start, errors.New("missing field value")
+		}
+		i++ // skip '='
+
+		vstart := i
+		if b[i] == '"' {
+			i++
+			for i < len(b) && b[i] != '"' {
+				if b[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++ // skip '"'
+		} else {
+			for i < len(b) && b[i] != ',' {
+				i++
+			}
+		}
+		if i > len(b) {
+			return nil, vstart, errors.New("unbalanced quotes")
+		}
+		v, err := parseFieldValue(b[vstart:i])
+		if err != nil {
+			return nil, vstart, err
+		}
+		fields = append(fields, Field{
+			Key:   unescape(k, tagEscapes),
+			Value: v,
+		})
+		i++ // skip ','
+	}
+	return fields, 0, nil
+}
+
+// parsePoint parses line into a point. It also returns the series key of the point as it appears in line,
+// but with tags sorted. Returned errors are of type *ParseError with Column and Excerpt set.
+func parsePoint(line []byte) (string, *Point, error) {
+	// scan the first block which is measurement[,tag1=value1,tag2=value=2...]
+	pos, key, err := scanKey(line, 0)
+	if err != nil {
+		return "", nil, newParseError(line, pos, err)
+	}
+	// measurement name is required
+	if len(key) == 0 {
+		return "", nil, newParseError(line, pos, errors.New("missing measurement"))
+	}
+	if len(key) > models.MaxKeyLength {
+		return "", nil, newParseError(line, 0, errors.Errorf("max key length exceeded: %v > %v", len(key), models.MaxKeyLength))
+	}
+
+	// scan the second block which is field1=value1[,field2=value2,...]
+	fieldsStart := skipWhitespace(line, pos)
+	pos, fieldBytes, err := scanFields(line, pos)
+	if err != nil {
+		return "", nil, newParseError(line, pos, err)
+	}
+	// at least one field is required
+	if len(fieldBytes) == 0 {
+		return "", nil, newParseError(line, pos, errors.New("missing fields"))
+	}
+	fields, i, err := parseFields(fieldBytes)
+	if err != nil {
+		return "", nil, newParseError(line, fieldsStart+i, err)
+	}
+
+	// scan the last block which is the timestamp
+	pos, ts, err := scanTime(line, pos)
+	if err != nil {
+		return "", nil, newParseError(line, pos, err)
+	}
+	if len(ts) == 0 {
+		return "", nil, newParseError(line, pos, errors.New("missing timestamp"))
+	}
+	t, err := parseIntBytes(ts, 10, 64)
+	if err != nil {
+		return "", nil, newParseError(line, pos-len(ts), errors.Wrap(err, "invalid timestamp"))
+	}
+	if pos = skipWhitespace(line, pos); pos < len(line) {
+		return "", nil, newParseError(line, pos, errors.New("unexpected trailing data"))
+	}
+
+	measurement, tags := parseKey(key)
+	return string(key), &Point{
+		Measurement: measurement,
+		Tags:        tags,
+		Fields:      fields,
+		Time:        t,
+	}, nil
+}
+
+// ParsePoint parses a single line of line protocol. Returned errors are of type *ParseError.
+func ParsePoint(line []byte) (*Point, error) {
+	_, p, err := parsePoint(line)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
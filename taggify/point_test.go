@@ -0,0 +1,94 @@
+package taggify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePoint(t *testing.T) {
+	a := assert.New(t)
+
+	p, err := ParsePoint([]byte(`cpu\ load,region=us\,west,host=a\=b f=1.5,i=-42i,u=42u,b=true,s="say \"hi\"" 1511629912071663075`))
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Equal(&Point{
+		Measurement: "cpu load",
+		Tags: []Tag{
+			{Key: "host", Value: "a=b"},
+			{Key: "region", Value: "us,west"},
+		},
+		Fields: []Field{
+			{Key: "f", Value: 1.5},
+			{Key: "i", Value: int64(-42)},
+			{Key: "u", Value: uint64(42)},
+			{Key: "b", Value: true},
+			{Key: "s", Value: `say "hi"`},
+		},
+		Time: 1511629912071663075,
+	}, p)
+	a.Equal(`cpu\ load,host=a\=b,region=us\,west f=1.5,i=-42i,u=42u,b=true,s="say \"hi\"" 1511629912071663075`, p.String())
+
+	q, err := ParsePoint([]byte(p.String()))
+	a.NoError(err)
+	a.Equal(p, q)
+
+	for _, line := range []string{
+		`test`,
+		`test f=1`,
+		`test f= 1`,
+		`test f=1 1 trailing`,
+		`test f=1x 1`,
+		`test f="unbalanced 1`,
+	} {
+		_, err := ParsePoint([]byte(line))
+		a.IsType(&ParseError{}, err, "line %q", line)
+	}
+}
+
+func TestPointModify(t *testing.T) {
+	a := assert.New(t)
+
+	p := &Point{Measurement: "test", Time: 1}
+	p.SetTag("b", "2")
+	p.SetTag("a", "1")
+	p.SetTag("c", "3")
+	p.SetTag("b", "two")
+	a.Equal([]Tag{{"a", "1"}, {"b", "two"}, {"c", "3"}}, p.Tags)
+	p.DeleteTag("a")
+
+	p.SetField("x", int64(1))
+	p.SetField("y", "foo")
+	p.SetField("x", 2.0)
+	p.DeleteField("y")
+
+	c := p.Copy()
+	c.SetTag("b", "changed")
+	c.SetField("z", false)
+
+	a.Equal("test,b=two,c=3 x=2 1", p.String())
+	a.Equal("test,b=changed,c=3 x=2,z=false 1", c.String())
+
+	p.Tags = []Tag{{"z", "1"}, {"a", "2"}}
+	a.Equal("test,a=2,z=1", p.SeriesKey())
+	a.Equal([]Tag{{"z", "1"}, {"a", "2"}}, p.Tags)
+}
+
+func TestFormatValue(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("42", FormatValue(int64(42)))
+	a.Equal("42", FormatValue(uint64(42)))
+	a.Equal("42", FormatValue(42.0))
+	a.Equal("4.2", FormatValue(4.2))
+	// floats are never formatted in exponent form, as by InfluxDB
+	a.Equal("100000000", FormatValue(1e8))
+	a.Equal("0.0000001", FormatValue(1e-7))
+	a.Equal("m value=100000000,small=0.0000001 1", string((&Point{
+		Measurement: "m",
+		Fields:      []Field{{Key: "value", Value: 1e8}, {Key: "small", Value: 1e-7}},
+		Time:        1,
+	}).AppendLine(nil)))
+	a.Equal("true", FormatValue(true))
+	a.Equal("foo", FormatValue("foo"))
+}
@@ -0,0 +1,162 @@
+package taggify
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Section is a section of an export produced by influx_inspect.
+type Section int32
+
+const (
+	// SectionHeader contains the comments and DDL preceding the data.
+	SectionHeader Section = iota
+	// SectionTSM contains the data exported from TSM files.
+	SectionTSM
+	// SectionWAL contains the data exported from WAL files.
+	SectionWAL
+	// SectionStream is plain line protocol read by Stream.
+	SectionStream
+	// SectionQuery is data queried by TransformQuery.
+	SectionQuery
+)
+
+func (s Section) String() string {
+	switch s {
+	case SectionHeader:
+		return "header"
+	case SectionTSM:
+		return "tsm"
+	case SectionWAL:
+		return "wal"
+	case SectionStream:
+		return "stream"
+	case SectionQuery:
+		return "query"
+	}
+	return "unknown"
+}
+
+// Approximate memory overhead of the grouping map entries in bytes,
+// not accounting for the length of keys and values.
+const (
+	seriesOverhead = 96
+	rowOverhead    = 96
+	fieldOverhead  = 32
+)
+
+// Progress tracks the progress of Transform.
+// All methods are safe for concurrent use and may be called on nil *Progress.
+type Progress struct {
+	bytes   int64
+	lines   int64
+	points  int64
+	mapSize int64
+	section int32
+}
+
+func (p *Progress) addBytes(n int) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.bytes, int64(n))
+}
+
+func (p *Progress) addLine() {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.lines, 1)
+}
+
+func (p *Progress) addPoint() {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.points, 1)
+}
+
+// grow records growth of the grouping map by n bytes.
+func (p *Progress) grow(n int) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.mapSize, int64(n))
+}
+
+func (p *Progress) setSection(s Section) {
+	if p == nil {
+		return
+	}
+	atomic.StoreInt32(&p.section, int32(s))
+}
+
+// ProgressReader counts the bytes read from the underlying io.Reader in Progress.
+type ProgressReader struct {
+	io.Reader
+	Progress *Progress
+}
+
+func (r ProgressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.Progress.addBytes(n)
+	return n, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Line returns a human-readable description of p given that size bytes are expected
+// to be read in total and the transformation is running for elapsed.
+// If size is not positive, no ETA is computed.
+func (p *Progress) Line(size int64, elapsed time.Duration) string {
+	bytes := atomic.LoadInt64(&p.bytes)
+	s := fmt.Sprintf("Read %s", formatBytes(bytes))
+	if size > 0 {
+		s += fmt.Sprintf(" of %s (%.1f%%)", formatBytes(size), float64(bytes)/float64(size)*100)
+	}
+	s += fmt.Sprintf(", %d lines, %d points written, section %s, grouping map ~%s",
+		atomic.LoadInt64(&p.lines),
+		atomic.LoadInt64(&p.points),
+		Section(atomic.LoadInt32(&p.section)),
+		formatBytes(atomic.LoadInt64(&p.mapSize)),
+	)
+	if elapsed <= 0 || bytes == 0 {
+		return s
+	}
+	rate := float64(bytes) / elapsed.Seconds()
+	s += fmt.Sprintf(", %s/s", formatBytes(int64(rate)))
+	if size > bytes {
+		eta := time.Duration(float64(size-bytes) / rate * float64(time.Second))
+		s += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return s
+}
+
+// Report writes the state of p to w every interval until done is closed.
+// size is the total number of bytes expected to be read, see Line.
+func (p *Progress) Report(w io.Writer, size int64, interval time.Duration, done <-chan struct{}) {
+	start := time.Now()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			fmt.Fprintln(w, p.Line(size, time.Since(start)))
+		}
+	}
+}
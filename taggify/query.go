@@ -0,0 +1,344 @@
+package taggify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxql"
+	"github.com/pkg/errors"
+)
+
+// DefaultQueryChunkSize is the default number of points returned by InfluxDB in a single chunk.
+const DefaultQueryChunkSize = 10000
+
+// QueryConfig selects the data read by TransformQuery.
+type QueryConfig struct {
+	// URL is the base URL of InfluxDB, e.g. http://localhost:8086.
+	URL string
+	// Username and Password are used for authentication, if Username is not empty.
+	Username string
+	Password string
+	// Token, if not empty, is used for authentication with the InfluxDB 1.x compatibility API of InfluxDB 2.x.
+	Token string
+	// Database, if not empty, is the only database read. All databases except _internal are read otherwise.
+	Database string
+	// RetentionPolicy, if not empty, is the only retention policy read. Requires Database.
+	RetentionPolicy string
+	// Start and End, if not zero, limit the time range of the read data, both inclusive.
+	Start, End time.Time
+	// ChunkSize is the number of points in a chunk of a query response, DefaultQueryChunkSize if zero.
+	ChunkSize int
+	// Client is the HTTP client used, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// queryResult is a result of a single statement in a response of the /query endpoint.
+type queryResult struct {
+	Series []struct {
+		Name    string            `json:"name"`
+		Tags    map[string]string `json:"tags"`
+		Columns []string          `json:"columns"`
+		Values  [][]interface{}   `json:"values"`
+	} `json:"series"`
+	Error string `json:"error"`
+}
+
+// querier runs InfluxQL queries over HTTP.
+type querier struct {
+	conf QueryConfig
+}
+
+// query runs statement q on database db and calls f for each chunk of the result.
+func (qr *querier) query(ctx context.Context, db, q string, f func(*queryResult) error) error {
+	v := url.Values{
+		"q":          {q},
+		"epoch":      {"ns"},
+		"chunked":    {"true"},
+		"chunk_size": {strconv.Itoa(qr.conf.ChunkSize)},
+	}
+	if db != "" {
+		v.Set("db", db)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(qr.conf.URL, "/")+"/query?"+v.Encode(), nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	switch {
+	case qr.conf.Token != "":
+		req.Header.Set("Authorization", "Token "+qr.conf.Token)
+	case qr.conf.Username != "":
+		req.SetBasicAuth(qr.conf.Username, qr.conf.Password)
+	}
+	resp, err := qr.conf.Client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to query %q", q)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Wrapf(checkResponse(resp), "failed to query %q", q)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	// json.Number preserves integers, which do not fit into a float64
+	dec.UseNumber()
+	for {
+		var chunk struct {
+			Results []queryResult `json:"results"`
+			Error   string        `json:"error"`
+		}
+		if err := dec.Decode(&chunk); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to decode response to %q", q)
+		}
+		if chunk.Error != "" {
+			return errors.Errorf("failed to query %q: %s", q, chunk.Error)
+		}
+		for i := range chunk.Results {
+			if chunk.Results[i].Error != "" {
+				return errors.Errorf("failed to query %q: %s", q, chunk.Results[i].Error)
+			}
+			if err := f(&chunk.Results[i]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// column runs statement q on database db and returns the values of column name of all returned series.
+func (qr *querier) column(ctx context.Context, db, q, name string) ([]string, error) {
+	var vs []string
+	err := qr.query(ctx, db, q, func(res *queryResult) error {
+		for _, s := range res.Series {
+			i := indexOf(s.Columns, name)
+			if i < 0 {
+				return errors.Errorf("response to %q has no column %s", q, name)
+			}
+			for _, row := range s.Values {
+				if v, ok := row[i].(string); ok {
+					vs = append(vs, v)
+				}
+			}
+		}
+		return nil
+	})
+	return vs, err
+}
+
+// fieldTypes returns the types of fields of measurement m in retention policy rp of database db.
+// JSON does not distinguish between integers and floats, so the types are needed to restore the values.
+func (qr *querier) fieldTypes(ctx context.Context, db, rp, m string) (map[string]string, error) {
+	types := make(map[string]string)
+	err := qr.query(ctx, db, fmt.Sprintf("SHOW FIELD KEYS ON %s FROM %s.%s", influxql.QuoteIdent(db), influxql.QuoteIdent(rp), influxql.QuoteIdent(m)), func(res *queryResult) error {
+		for _, s := range res.Series {
+			k, t := indexOf(s.Columns, "fieldKey"), indexOf(s.Columns, "fieldType")
+			if k < 0 || t < 0 {
+				return errors.New("response to SHOW FIELD KEYS has no fieldKey or fieldType column")
+			}
+			for _, row := range s.Values {
+				key, _ := row[k].(string)
+				typ, _ := row[t].(string)
+				types[key] = typ
+			}
+		}
+		return nil
+	})
+	return types, err
+}
+
+// fieldValue converts v decoded from a query response to the Go type of a field of type typ.
+func fieldValue(v interface{}, typ string) (interface{}, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v, nil
+	}
+	switch typ {
+	case "integer":
+		return strconv.ParseInt(string(n), 10, 64)
+	case "unsigned":
+		return strconv.ParseUint(string(n), 10, 64)
+	}
+	return strconv.ParseFloat(string(n), 64)
+}
+
+// readMeasurement groups the points of measurement m in retention policy rp of database db within [start, end] into entries.
+func (qr *querier) readMeasurement(ctx context.Context, db, rp, m string, start, end int64, entries map[string]map[int64]*Point, st *Stats, opts *Options) error {
+	types, err := qr.fieldTypes(ctx, db, rp, m)
+	if err != nil {
+		return err
+	}
+	q := fmt.Sprintf("SELECT * FROM %s.%s", influxql.QuoteIdent(rp), influxql.QuoteIdent(m))
+	var conds []string
+	if start != math.MinInt64 {
+		conds = append(conds, fmt.Sprintf("time >= %d", start))
+	}
+	if end != math.MaxInt64 {
+		conds = append(conds, fmt.Sprintf("time <= %d", end))
+	}
+	if len(conds) > 0 {
+		q += " WHERE " + strings.Join(conds, " AND ")
+	}
+	q += " GROUP BY *"
+
+	return qr.query(ctx, db, q, func(res *queryResult) error {
+		for _, s := range res.Series {
+			ti := indexOf(s.Columns, "time")
+			if ti < 0 {
+				return errors.Errorf("response to %q has no time column", q)
+			}
+			// series without a tag have it set to an empty string
+			tags := make([]Tag, 0, len(s.Tags))
+			for k, v := range s.Tags {
+				if v != "" {
+					tags = append(tags, Tag{Key: k, Value: v})
+				}
+			}
+			sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+
+			for _, row := range s.Values {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				tn, ok := row[ti].(json.Number)
+				if !ok {
+					return errors.Errorf("invalid time %v in response to %q", row[ti], q)
+				}
+				t, err := tn.Int64()
+				if err != nil {
+					return errors.Wrapf(err, "invalid time in response to %q", q)
+				}
+				p := &Point{
+					Measurement: s.Name,
+					Tags:        append([]Tag(nil), tags...),
+					Time:        t,
+				}
+				for i, c := range s.Columns {
+					if i == ti || row[i] == nil {
+						continue
+					}
+					v, err := fieldValue(row[i], types[c])
+					if err != nil {
+						return errors.Wrapf(err, "invalid value of field %s in response to %q", c, q)
+					}
+					p.Fields = append(p.Fields, Field{Key: c, Value: v})
+				}
+				if len(p.Fields) == 0 {
+					continue
+				}
+				st.LinesRead++
+				opts.Progress.addLine()
+				if opts.Hooks.Parsed != nil {
+					if ok, err := callHook(opts.Hooks.Parsed, p); err != nil {
+						return err
+					} else if !ok {
+						continue
+					}
+				}
+				group(entries, p.SeriesKey(), p, opts.Progress)
+			}
+		}
+		return nil
+	})
+}
+
+// TransformQuery reads the data selected by conf through the InfluxQL query API of InfluxDB,
+// converts fields specified in opts to tags and writes the result to w in the format of influx_inspect export,
+// or to opts.Writer, if it is set.
+// Measurements of each database are enumerated with SHOW MEASUREMENTS and SHOW SERIES, their points are read by
+// chunked SELECT * ... GROUP BY * queries and grouped separately for each database and retention policy,
+// one retention policy at a time.
+// Options related to parsing and the input offset are ignored.
+func TransformQuery(ctx context.Context, conf QueryConfig, w io.Writer, opts Options) (st Stats, err error) {
+	if conf.URL == "" {
+		return st, errors.New("URL must be specified")
+	}
+	if conf.RetentionPolicy != "" && conf.Database == "" {
+		return st, errors.New("database must be specified to select a retention policy")
+	}
+	start, end, err := timeRange(conf.Start, conf.End)
+	if err != nil {
+		return st, err
+	}
+	if conf.ChunkSize <= 0 {
+		conf.ChunkSize = DefaultQueryChunkSize
+	}
+	if conf.Client == nil {
+		conf.Client = http.DefaultClient
+	}
+	qr := &querier{conf: conf}
+
+	dbs := []string{conf.Database}
+	if conf.Database == "" {
+		all, err := qr.column(ctx, "", "SHOW DATABASES", "name")
+		if err != nil {
+			return st, err
+		}
+		dbs = dbs[:0]
+		for _, db := range all {
+			if db != "_internal" {
+				dbs = append(dbs, db)
+			}
+		}
+		sort.Strings(dbs)
+	}
+
+	opts.Progress.setSection(SectionQuery)
+	// measurements[i] are the measurements of the database of keys[i]
+	var keys []dbrp
+	var measurements [][]string
+	for _, db := range dbs {
+		rps := []string{conf.RetentionPolicy}
+		if conf.RetentionPolicy == "" {
+			if rps, err = qr.column(ctx, db, "SHOW RETENTION POLICIES ON "+influxql.QuoteIdent(db), "name"); err != nil {
+				return st, err
+			}
+			sort.Strings(rps)
+		}
+		ms, err := qr.column(ctx, db, "SHOW MEASUREMENTS ON "+influxql.QuoteIdent(db), "name")
+		if err != nil {
+			return st, err
+		}
+		// measurements without series only remain in the index until it is compacted
+		var dbms []string
+		for _, m := range ms {
+			series, err := qr.column(ctx, db, fmt.Sprintf("SHOW SERIES ON %s FROM %s LIMIT 1", influxql.QuoteIdent(db), influxql.QuoteIdent(m)), "key")
+			if err != nil {
+				return st, err
+			}
+			if len(series) > 0 {
+				dbms = append(dbms, m)
+			}
+		}
+		for _, rp := range rps {
+			keys = append(keys, dbrp{db: db, rp: rp})
+			measurements = append(measurements, dbms)
+		}
+	}
+	return st, writeBlocks(ctx, w, keys, func(i int, st *Stats, opts *Options) (map[string]map[int64]*Point, error) {
+		entries := make(map[string]map[int64]*Point)
+		for _, m := range measurements[i] {
+			if err := qr.readMeasurement(ctx, keys[i].db, keys[i].rp, m, start, end, entries, st, opts); err != nil {
+				return nil, err
+			}
+		}
+		return entries, nil
+	}, start, end, &st, opts)
+}
+
+func indexOf(ss []string, s string) int {
+	for i, v := range ss {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,209 @@
+package taggify
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeQuery is a fake /query endpoint of InfluxDB 1.x, which responds to known queries with canned chunks.
+type fakeQuery struct {
+	responses map[string][]string
+	queries   []string
+}
+
+func (f *fakeQuery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if r.URL.Path != "/query" || q.Get("chunked") != "true" || q.Get("epoch") != "ns" {
+		http.Error(w, `{"error":"unexpected request"}`, http.StatusBadRequest)
+		return
+	}
+	if u, p, _ := r.BasicAuth(); u != "user" || p != "pass" {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":"authorization failed"}`)
+		return
+	}
+	f.queries = append(f.queries, q.Get("db")+": "+q.Get("q"))
+	chunks, ok := f.responses[q.Get("q")]
+	if !ok {
+		io.WriteString(w, `{"results":[{"statement_id":0,"error":"unknown query"}]}`)
+		return
+	}
+	for _, c := range chunks {
+		io.WriteString(w, c+"\n")
+	}
+}
+
+func TestTransformQuery(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeQuery{responses: map[string][]string{
+		`SHOW DATABASES`: {
+			`{"results":[{"statement_id":0,"series":[{"name":"databases","columns":["name"],"values":[["_internal"],["test"]]}]}]}`,
+		},
+		`SHOW RETENTION POLICIES ON test`: {
+			`{"results":[{"statement_id":0,"series":[{"columns":["name","duration","shardGroupDuration","replicaN","default"],"values":[["autogen","0s","168h0m0s",1,true]]}]}]}`,
+		},
+		`SHOW MEASUREMENTS ON test`: {
+			`{"results":[{"statement_id":0,"series":[{"name":"measurements","columns":["name"],"values":[["dropped"],["test"]]}]}]}`,
+		},
+		`SHOW SERIES ON test FROM dropped LIMIT 1`: {
+			`{"results":[{"statement_id":0}]}`,
+		},
+		`SHOW SERIES ON test FROM test LIMIT 1`: {
+			`{"results":[{"statement_id":0,"series":[{"columns":["key"],"values":[["test,id=foo"]]}]}]}`,
+		},
+		`SHOW FIELD KEYS ON test FROM autogen.test`: {
+			`{"results":[{"statement_id":0,"series":[{"name":"test","columns":["fieldKey","fieldType"],"values":[["big","unsigned"],["idd","string"],["int","integer"],["ok","boolean"],["value","float"]]}]}]}`,
+		},
+		`SELECT * FROM autogen.test GROUP BY *`: {
+			`{"results":[{"statement_id":0,"series":[{"name":"test","tags":{"id":"foo","other":""},"columns":["time","big","idd","int","ok","value"],"values":[[1,null,"bar",42,null,1],[2,null,null,43,true,null]]}],"partial":true}]}`,
+			`{"results":[{"statement_id":0,"series":[{"name":"test","tags":{"id":"foo","other":""},"columns":["time","big","idd","int","ok","value"],"values":[[3,18446744073709551615,"baz",null,null,2.5]]},{"name":"test","tags":{"id":"","other":"x"},"columns":["time","big","idd","int","ok","value"],"values":[[1511629912071663075,null,null,null,null,3]]}]}]}`,
+		},
+		`SELECT * FROM autogen.test WHERE time >= 2 AND time <= 3 GROUP BY *`: {
+			`{"results":[{"statement_id":0,"series":[{"name":"test","tags":{"id":"foo","other":""},"columns":["time","big","idd","int","ok","value"],"values":[[2,null,null,43,true,null]]}]}]}`,
+		},
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	out := &bytes.Buffer{}
+	st, err := TransformQuery(context.Background(), QueryConfig{
+		URL:      srv.URL,
+		Username: "user",
+		Password: "pass",
+	}, out, Options{
+		Fields: []string{"idd"},
+	})
+	a.NoError(err)
+	a.Equal(4, st.LinesRead)
+	a.Equal(map[string]int{"test": 2}, st.SeriesBefore)
+	a.Equal(map[string]int{"test": 4}, st.SeriesAfter)
+	a.NotContains(fake.queries, "test: SHOW FIELD KEYS ON test FROM autogen.dropped")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	a.Equal([]string{
+		"# INFLUXDB EXPORT: 1677-09-21T00:12:43Z - 2262-04-11T23:47:16Z",
+		"# DDL",
+		"CREATE DATABASE test WITH NAME autogen",
+		"# DML",
+		"# CONTEXT-DATABASE:test",
+		"# CONTEXT-RETENTION-POLICY:autogen",
+		"# writing tsm data",
+	}, lines[:7])
+	data := append([]string(nil), lines[7:]...)
+	sort.Strings(data)
+	a.Equal([]string{
+		"test,id=foo int=43i 2",
+		"test,id=foo ok=true 2",
+		"test,id=foo,idd=bar int=42i 1",
+		"test,id=foo,idd=bar value=1 1",
+		"test,id=foo,idd=baz big=18446744073709551615u 3",
+		"test,id=foo,idd=baz value=2.5 3",
+		"test,other=x value=3 1511629912071663075",
+	}, data)
+
+	out.Reset()
+	_, err = TransformQuery(context.Background(), QueryConfig{
+		URL:             srv.URL,
+		Username:        "user",
+		Password:        "pass",
+		Database:        "test",
+		RetentionPolicy: "autogen",
+		Start:           time.Unix(0, 2),
+		End:             time.Unix(0, 3),
+	}, out, Options{})
+	a.NoError(err)
+	a.Contains(out.String(), "# writing tsm data\ntest,id=foo int=43i 2\ntest,id=foo ok=true 2\n")
+
+	_, err = TransformQuery(context.Background(), QueryConfig{
+		URL:      srv.URL,
+		Username: "user",
+		Password: "pass",
+		Database: "missing",
+	}, out, Options{})
+	a.EqualError(err, `failed to query "SHOW RETENTION POLICIES ON missing": unknown query`)
+
+	_, err = TransformQuery(context.Background(), QueryConfig{URL: srv.URL}, out, Options{})
+	if a.Error(err) {
+		a.Contains(err.Error(), "401 Unauthorized: authorization failed")
+	}
+}
+
+func TestTransformQueryMaxSeries(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeQuery{responses: map[string][]string{
+		`SHOW RETENTION POLICIES ON test`: {
+			`{"results":[{"statement_id":0,"series":[{"columns":["name","duration","shardGroupDuration","replicaN","default"],"values":[["autogen","0s","168h0m0s",1,true],["week","168h0m0s","24h0m0s",1,false]]}]}]}`,
+		},
+		`SHOW MEASUREMENTS ON test`: {
+			`{"results":[{"statement_id":0,"series":[{"name":"measurements","columns":["name"],"values":[["test"]]}]}]}`,
+		},
+		`SHOW SERIES ON test FROM test LIMIT 1`: {
+			`{"results":[{"statement_id":0,"series":[{"columns":["key"],"values":[["test,id=foo"]]}]}]}`,
+		},
+		`SHOW FIELD KEYS ON test FROM autogen.test`: {
+			`{"results":[{"statement_id":0,"series":[{"name":"test","columns":["fieldKey","fieldType"],"values":[["idd","string"],["value","float"]]}]}]}`,
+		},
+		`SHOW FIELD KEYS ON test FROM week.test`: {
+			`{"results":[{"statement_id":0,"series":[{"name":"test","columns":["fieldKey","fieldType"],"values":[["idd","string"],["value","float"]]}]}]}`,
+		},
+		`SELECT * FROM autogen.test GROUP BY *`: {
+			`{"results":[{"statement_id":0,"series":[{"name":"test","tags":{"id":"foo"},"columns":["time","idd","value"],"values":[[1,"bar",1],[2,"baz",2]]}]}]}`,
+		},
+		`SELECT * FROM week.test GROUP BY *`: {
+			`{"results":[{"statement_id":0,"series":[{"name":"test","tags":{"id":"foo"},"columns":["time","idd","value"],"values":[[3,"bar",3]]}]}]}`,
+		},
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	conf := QueryConfig{
+		URL:      srv.URL,
+		Username: "user",
+		Password: "pass",
+		Database: "test",
+	}
+	out := &bytes.Buffer{}
+	st, err := TransformQuery(context.Background(), conf, out, Options{
+		Fields:    []string{"idd"},
+		MaxSeries: 3,
+	})
+	a.NoError(err)
+	// the data is queried twice, but only counted once
+	a.Equal(3, st.LinesRead)
+	a.Equal(3, st.PointsEmitted)
+	a.Equal(map[string]int{"test": 3}, st.SeriesAfter)
+	var selects []string
+	for _, q := range fake.queries {
+		if strings.Contains(q, "SELECT") {
+			selects = append(selects, q)
+		}
+	}
+	a.Equal([]string{
+		"test: SELECT * FROM autogen.test GROUP BY *",
+		"test: SELECT * FROM week.test GROUP BY *",
+		"test: SELECT * FROM autogen.test GROUP BY *",
+		"test: SELECT * FROM week.test GROUP BY *",
+	}, selects)
+	a.Contains(out.String(), "# CONTEXT-RETENTION-POLICY:autogen\n# writing tsm data\n")
+	a.Contains(out.String(), "# CONTEXT-RETENTION-POLICY:week\n# writing tsm data\ntest,id=foo,idd=bar value=3 3\n")
+
+	out.Reset()
+	st, err = TransformQuery(context.Background(), conf, out, Options{
+		Fields:    []string{"idd"},
+		MaxSeries: 2,
+	})
+	a.EqualError(err, "result would contain 3 series, which exceeds the limit of 2")
+	a.Equal(3, st.LinesRead)
+	a.Empty(out.String())
+}
@@ -0,0 +1,85 @@
+package taggify
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrorPolicy determines how lines, which fail to parse, are handled.
+// It implements flag.Value.
+type ErrorPolicy struct {
+	// MaxErrors is the maximum number of lines, which may be rejected before the transformation is aborted.
+	// Negative value means no limit.
+	MaxErrors int
+}
+
+var (
+	// AbortOnError aborts the transformation on the first error.
+	AbortOnError = ErrorPolicy{}
+	// SkipOnError rejects any number of lines.
+	SkipOnError = ErrorPolicy{MaxErrors: -1}
+)
+
+const maxErrorsPrefix = "max-errors="
+
+func (p ErrorPolicy) String() string {
+	switch {
+	case p.MaxErrors < 0:
+		return "skip"
+	case p.MaxErrors == 0:
+		return "abort"
+	}
+	return maxErrorsPrefix + strconv.Itoa(p.MaxErrors)
+}
+
+// Set parses s, which is one of 'abort', 'skip' or 'max-errors=N'.
+func (p *ErrorPolicy) Set(s string) error {
+	switch {
+	case s == "abort":
+		*p = AbortOnError
+	case s == "skip":
+		*p = SkipOnError
+	case strings.HasPrefix(s, maxErrorsPrefix):
+		n, err := strconv.Atoi(strings.TrimPrefix(s, maxErrorsPrefix))
+		if err != nil || n < 0 {
+			return errors.Errorf("invalid error count in '%s'", s)
+		}
+		*p = ErrorPolicy{MaxErrors: n}
+	default:
+		return errors.Errorf("unknown error policy '%s', must be one of 'abort', 'skip' or 'max-errors=N'", s)
+	}
+	return nil
+}
+
+// tolerates reports whether n rejected lines are tolerated by p.
+func (p ErrorPolicy) tolerates(n int) bool {
+	return p.MaxErrors < 0 || n <= p.MaxErrors
+}
+
+// rejectWriter writes rejected lines in the following format:
+//
+//	# line=<number> offset=<byte offset> column=<column> error=<error>
+//	<line>
+type rejectWriter struct {
+	w *bufio.Writer
+}
+
+func newRejectWriter(w io.Writer) *rejectWriter {
+	return &rejectWriter{w: bufio.NewWriter(w)}
+}
+
+func (rw *rejectWriter) reject(line string, perr *ParseError) error {
+	if _, err := fmt.Fprintf(rw.w, "# line=%d offset=%d column=%d error=%s\n%s\n", perr.Line, perr.Offset, perr.Column, perr.Err, line); err != nil {
+		return errors.Wrap(err, "failed to write rejected line")
+	}
+	return nil
+}
+
+func (rw *rejectWriter) Flush() error {
+	return rw.w.Flush()
+}
@@ -0,0 +1,210 @@
+package taggify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxReportedErrors is the maximum amount of parse error messages retained in Stats.
+const maxReportedErrors = 10
+
+// Stats describes the transformation performed by Transform.
+type Stats struct {
+	// LinesRead is the number of lines read from input.
+	LinesRead int `json:"lines_read"`
+	// PointsEmitted is the number of points in the data section of the output.
+	PointsEmitted int `json:"points_emitted"`
+	// ParseErrors is the number of lines in the data section, which could not be parsed.
+	ParseErrors int `json:"parse_errors"`
+	// Errors contains the first maxReportedErrors parse error messages.
+	Errors []string `json:"errors,omitempty"`
+
+	// SeriesBefore is the number of distinct series per measurement in the input.
+	SeriesBefore map[string]int `json:"series_before"`
+	// SeriesAfter is the number of distinct series per measurement in the output.
+	SeriesAfter map[string]int `json:"series_after"`
+	// SeriesCreated is the number of series in the output, which are not present in the input.
+	SeriesCreated int `json:"series_created"`
+	// FieldValues is the number of distinct values per promoted field.
+	FieldValues map[string]int `json:"field_values"`
+	// Promoted is the number of rows per measurement, where the field was promoted to a tag.
+	Promoted map[string]map[string]int `json:"promoted"`
+	// Missing is the number of rows per measurement, which are missing the promoted field.
+	Missing map[string]map[string]int `json:"missing"`
+}
+
+// parseError records a parse error in st.
+func (st *Stats) parseError(err error) {
+	st.ParseErrors++
+	if len(st.Errors) < maxReportedErrors {
+		st.Errors = append(st.Errors, err.Error())
+	}
+}
+
+// collect computes the impact of promoting fields with given names to tags.
+// Each of blocks contains the grouped rows of a single database and retention policy.
+func (st *Stats) collect(blocks []map[string]map[int64]*Point, names []string) {
+	c := newCollector(st, names)
+	for _, entries := range blocks {
+		c.add(entries)
+	}
+}
+
+// collector computes the impact of promoting fields to tags block by block, so that the blocks
+// need not be held in memory at once.
+type collector struct {
+	st    *Stats
+	names []string
+	// values are the distinct values of the promoted fields.
+	values map[string]map[string]struct{}
+}
+
+// newCollector returns a collector, which records the impact of promoting fields with given names in st.
+func newCollector(st *Stats, names []string) *collector {
+	st.SeriesBefore = make(map[string]int)
+	st.SeriesAfter = make(map[string]int)
+	st.FieldValues = make(map[string]int, len(names))
+	st.Promoted = make(map[string]map[string]int)
+	st.Missing = make(map[string]map[string]int)
+
+	values := make(map[string]map[string]struct{}, len(names))
+	for _, name := range names {
+		values[name] = make(map[string]struct{})
+		st.FieldValues[name] = 0
+	}
+	return &collector{st: st, names: names, values: values}
+}
+
+// add records the impact on entries, which contains the grouped rows of a single database and retention policy.
+func (c *collector) add(entries map[string]map[int64]*Point) {
+	st, names := c.st, c.names
+	after := make(map[string]struct{})
+	for _, rows := range entries {
+		var m string
+		for _, p := range rows {
+			m = p.Measurement
+			break
+		}
+		st.SeriesBefore[m]++
+
+		promoted, ok := st.Promoted[m]
+		if !ok {
+			promoted = make(map[string]int, len(names))
+			st.Promoted[m] = promoted
+			for _, name := range names {
+				promoted[name] = 0
+			}
+		}
+		missing, ok := st.Missing[m]
+		if !ok {
+			missing = make(map[string]int, len(names))
+			st.Missing[m] = missing
+			for _, name := range names {
+				missing[name] = 0
+			}
+		}
+
+		for _, p := range rows {
+			n := len(p.Fields)
+			for _, name := range names {
+				v, ok := p.Field(name)
+				if !ok {
+					missing[name]++
+					continue
+				}
+				promoted[name]++
+				c.values[name][FormatValue(v)] = struct{}{}
+				n--
+			}
+			st.PointsEmitted += n
+
+			newKey := promotedKey(p, names)
+			if _, ok := after[newKey]; !ok {
+				after[newKey] = struct{}{}
+				st.SeriesAfter[m]++
+				if _, ok := entries[newKey]; !ok {
+					st.SeriesCreated++
+				}
+			}
+		}
+	}
+	for name, vs := range c.values {
+		st.FieldValues[name] = len(vs)
+	}
+}
+
+// checkMaxSeries returns an error if the result described by st contains more than max series.
+func (st *Stats) checkMaxSeries(max int) error {
+	if max <= 0 {
+		return nil
+	}
+	var n int
+	for _, c := range st.SeriesAfter {
+		n += c
+	}
+	if n > max {
+		return errors.Errorf("result would contain %d series, which exceeds the limit of %d", n, max)
+	}
+	return nil
+}
+
+// promotedKey returns the series key of p after the fields with given names are promoted to tags.
+func promotedKey(p *Point, names []string) string {
+	q := Point{
+		Measurement: p.Measurement,
+		Tags:        append([]Tag(nil), p.Tags...),
+	}
+	for _, name := range names {
+		if v, ok := p.Field(name); ok {
+			q.SetTag(name, FormatValue(v))
+		}
+	}
+	return q.SeriesKey()
+}
+
+func sortedKeys(m map[string]int) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// WriteReport writes st to w in specified format, which is either "text" or "json".
+func WriteReport(w io.Writer, st *Stats, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(st)
+	case "text", "":
+	default:
+		return errors.Errorf("unknown report format '%s'", format)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Lines read: %d\n", st.LinesRead)
+	fmt.Fprintf(&b, "Points emitted: %d\n", st.PointsEmitted)
+	fmt.Fprintf(&b, "Series created: %d\n", st.SeriesCreated)
+	for _, m := range sortedKeys(st.SeriesBefore) {
+		fmt.Fprintf(&b, "Measurement %s: %d series before, %d series after\n", m, st.SeriesBefore[m], st.SeriesAfter[m])
+		for _, f := range sortedKeys(st.Promoted[m]) {
+			fmt.Fprintf(&b, "\tField %s: promoted in %d rows, missing in %d rows\n", f, st.Promoted[m][f], st.Missing[m][f])
+		}
+	}
+	for _, f := range sortedKeys(st.FieldValues) {
+		fmt.Fprintf(&b, "Field %s: %d distinct values\n", f, st.FieldValues[f])
+	}
+	fmt.Fprintf(&b, "Parse errors: %d\n", st.ParseErrors)
+	for _, e := range st.Errors {
+		fmt.Fprintf(&b, "\t%s\n", e)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
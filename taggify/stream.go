@@ -0,0 +1,186 @@
+package taggify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultWindow is the default grouping window of Stream.
+const DefaultWindow = 100 * time.Millisecond
+
+// maxPending is the number of points buffered by Stream, after which they are written regardless of the window.
+const maxPending = 10000
+
+// streamLine is a line read by Stream. If the line is empty or a comment, p and perr are nil.
+type streamLine struct {
+	key  string
+	p    *Point
+	line string
+	perr *ParseError
+}
+
+// Stream reads plain line protocol from r point by point, converts fields specified in opts to tags
+// and writes the result to w, one point per line. Lines with equal series key and timestamp, which
+// arrive within window of the first of them, are merged into a single point.
+// Output is flushed after each window, or after each line if window is not positive.
+// It implements the protocol of the Telegraf execd processor and runs until r is exhausted or ctx is done.
+// opts.MaxSeries and opts.DryRun are ignored.
+func Stream(ctx context.Context, r io.Reader, w io.Writer, window time.Duration, opts Options) (st Stats, err error) {
+	buf := bufio.NewWriter(w)
+	defer func() {
+		if ferr := buf.Flush(); ferr != nil && err == nil {
+			err = errors.Wrap(ferr, "failed to write data")
+		}
+	}()
+
+	var rejects *rejectWriter
+	if opts.Rejects != nil {
+		rejects = newRejectWriter(opts.Rejects)
+		defer func() {
+			if ferr := rejects.Flush(); ferr != nil && err == nil {
+				err = errors.Wrap(ferr, "failed to write rejected lines")
+			}
+		}()
+	}
+	opts.DryRun = false
+	opts.Progress.setSection(SectionStream)
+
+	// lines are read in a separate goroutine, so that pending points are written while waiting for input.
+	lines := make(chan streamLine)
+	errc := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(lines)
+		sc := newLineReader(r, opts.MaxLineLength)
+		for sc.Scan() {
+			var l streamLine
+			if sc.tooLong {
+				l.line, l.perr = sc.Text(), sc.lineError(SectionStream)
+			} else if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 && line[0] != '#' {
+				var err error
+				if l.key, l.p, err = parsePoint(sc.Bytes()); err != nil {
+					l.line, l.perr = sc.Text(), err.(*ParseError)
+					l.perr.Section = SectionStream.String()
+					l.perr.Line = sc.n
+					l.perr.Offset = sc.offset
+				}
+			}
+			select {
+			case lines <- l:
+			case <-done:
+				return
+			}
+		}
+		errc <- sc.Err()
+	}()
+
+	e := &emitter{
+		w:          buf,
+		batch:      opts.BatchTransformer,
+		batchSize:  opts.BatchSize,
+		progress:   opts.Progress,
+		wholeLines: true,
+	}
+	defer func() {
+		st.PointsEmitted = e.written
+	}()
+
+	// pending points are grouped by series key and timestamp and kept in order of arrival.
+	groups := make(map[string]map[int64]*Point)
+	var pending []*Point
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	flush := func() error {
+		if timer != nil {
+			timer.Stop()
+			timeout = nil
+		}
+		for _, p := range pending {
+			if err := emitRow(ctx, e, p, &opts); err != nil {
+				return err
+			}
+		}
+		pending = pending[:0]
+		groups = make(map[string]map[int64]*Point)
+		if err := e.flush(ctx); err != nil {
+			return err
+		}
+		if err := buf.Flush(); err != nil {
+			return errors.Wrap(err, "failed to write data")
+		}
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := flush(); err != nil {
+				return st, err
+			}
+			return st, ctx.Err()
+
+		case <-timeout:
+			if err := flush(); err != nil {
+				return st, err
+			}
+
+		case l, ok := <-lines:
+			if !ok {
+				if err := <-errc; err != nil {
+					return st, errors.Wrap(err, "failed to read input")
+				}
+				return st, flush()
+			}
+			st.LinesRead++
+			opts.Progress.addLine()
+			if l.perr != nil {
+				if err := handleParseError(&st, rejects, &opts, l.line, l.perr); err != nil {
+					return st, err
+				}
+				continue
+			}
+			if l.p == nil {
+				continue
+			}
+			key, p := l.key, l.p
+			if opts.Hooks.Parsed != nil {
+				if ok, err := callHook(opts.Hooks.Parsed, p); err != nil {
+					return st, err
+				} else if !ok {
+					continue
+				}
+				key = p.SeriesKey()
+			}
+
+			rows, ok := groups[key]
+			if !ok {
+				rows = make(map[int64]*Point)
+				groups[key] = rows
+			}
+			if row, ok := rows[p.Time]; ok {
+				for _, f := range p.Fields {
+					row.SetField(f.Key, f.Value)
+				}
+				continue
+			}
+			rows[p.Time] = p
+			pending = append(pending, p)
+
+			switch {
+			case window <= 0, len(pending) >= maxPending:
+				if err := flush(); err != nil {
+					return st, err
+				}
+			case timeout == nil:
+				timer = time.NewTimer(window)
+				timeout = timer.C
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package taggify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	a := assert.New(t)
+
+	data := `# comment
+test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=42 1511629912071663075
+
+test,id=foo int=43 1511629912071663076
+test,id=foo int=4.2.1 1511629912071663076
+other,id=foo idd="baz",int=44 1511629912071663076`
+
+	out := &bytes.Buffer{}
+	rejects := &bytes.Buffer{}
+	st, err := Stream(context.Background(), strings.NewReader(data), out, time.Minute, Options{
+		Fields:  []string{"idd"},
+		OnError: SkipOnError,
+		Rejects: rejects,
+	})
+	a.NoError(err)
+	a.Equal(`test,id=foo,idd=bar int=42 1511629912071663075
+test,id=foo int=43 1511629912071663076
+other,id=foo,idd=baz int=44 1511629912071663076
+`, out.String())
+	a.Equal(7, st.LinesRead)
+	a.Equal(3, st.PointsEmitted)
+	a.Equal(1, st.ParseErrors)
+	a.Contains(rejects.String(), "test,id=foo int=4.2.1 1511629912071663076")
+
+	_, err = Stream(context.Background(), strings.NewReader(data), &bytes.Buffer{}, 0, Options{})
+	if a.Error(err) {
+		a.Contains(err.Error(), "stream section, line 6")
+	}
+}
+
+func TestStreamWindow(t *testing.T) {
+	a := assert.New(t)
+
+	pr, pw := io.Pipe()
+	outr, outw := io.Pipe()
+	defer outr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := Stream(ctx, pr, outw, 10*time.Millisecond, Options{
+			Fields: []string{"idd"},
+		})
+		outw.Close()
+		errc <- err
+	}()
+
+	out := bufio.NewReader(outr)
+	readLine := func() string {
+		lc := make(chan string, 1)
+		go func() {
+			line, _ := out.ReadString('\n')
+			lc <- line
+		}()
+		select {
+		case line := <-lc:
+			return line
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for output")
+		}
+		return ""
+	}
+
+	_, err := io.WriteString(pw, "test,id=foo idd=\"bar\" 1\ntest,id=foo int=42 1\n")
+	a.NoError(err)
+	a.Equal("test,id=foo,idd=bar int=42 1\n", readLine())
+
+	// the row is complete at this point, so it is not merged with the previous one
+	_, err = io.WriteString(pw, "test,id=foo int=43 1\n")
+	a.NoError(err)
+	a.Equal("test,id=foo int=43 1\n", readLine())
+
+	pw.Close()
+	a.NoError(<-errc)
+}
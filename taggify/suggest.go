@@ -0,0 +1,246 @@
+package taggify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Weights of the signals in the score of a Suggestion.
+const (
+	cardinalityWeight = 0.3
+	typeWeight        = 0.2
+	constancyWeight   = 0.3
+	presenceWeight    = 0.2
+)
+
+// typeScores are the scores of field types, which reflect how likely a field of the type holds a tag-like value.
+var typeScores = map[string]float64{
+	"string":   1,
+	"boolean":  1,
+	"integer":  0.5,
+	"unsigned": 0.5,
+	"float":    0,
+}
+
+// SuggestConfig configures Suggest.
+type SuggestConfig struct {
+	// MaxValues is the number of distinct values and series tracked per field, DefaultMaxValues if zero.
+	MaxValues int
+	// Top, if positive, is the number of returned suggestions.
+	Top int
+}
+
+// Suggestion is a field ranked by how tag-like it is.
+type Suggestion struct {
+	Database        string   `json:"database"`
+	RetentionPolicy string   `json:"retention_policy"`
+	Measurement     string   `json:"measurement"`
+	Field           string   `json:"field"`
+	Types           []string `json:"types"`
+	// Score is the weighted sum of the signals below between 0 and 1, higher scores are more tag-like.
+	Score float64 `json:"score"`
+	// Values is the number of distinct values of the field.
+	Values Cardinality `json:"values"`
+	// Constancy is the fraction of series, in which the value of the field never changes.
+	Constancy float64 `json:"constancy"`
+	// Presence is the fraction of rows containing the field, estimated as the number of points containing it
+	// relative to the most frequent field of the measurement.
+	Presence float64 `json:"presence"`
+	// SeriesBefore is the number of series of the measurement, SeriesAfter the projected number of series
+	// if the field is converted to a tag.
+	SeriesBefore Cardinality `json:"series_before"`
+	SeriesAfter  Cardinality `json:"series_after"`
+}
+
+// seriesValues are the values of a field in a series.
+type seriesValues struct {
+	first   string
+	changed bool
+	points  int64
+}
+
+// tagLikeStats are the statistics of a field gathered by Suggest.
+type tagLikeStats struct {
+	values *valueSet
+	// pairs are the distinct pairs of series and value.
+	pairs *valueSet
+	// series are the values of the field in each series, up to max series.
+	series    map[string]*seriesValues
+	max       int
+	truncated bool
+}
+
+func newTagLikeStats(max int) *tagLikeStats {
+	return &tagLikeStats{
+		values: newValueSet(max),
+		pairs:  newValueSet(max),
+		series: make(map[string]*seriesValues),
+		max:    max,
+	}
+}
+
+func (s *tagLikeStats) add(series, v string) {
+	s.values.add(v)
+	s.pairs.add(series + "\x00" + v)
+	sv, ok := s.series[series]
+	switch {
+	case !ok && len(s.series) == s.max:
+		s.truncated = true
+		return
+	case !ok:
+		sv = &seriesValues{first: v}
+		s.series[series] = sv
+	case sv.first != v:
+		sv.changed = true
+	}
+	sv.points++
+}
+
+// cardinalityScore scores a field with values distinct values in points points, fewer values score higher.
+func cardinalityScore(values Cardinality, points int64) float64 {
+	switch {
+	case values.Truncated:
+		return 0
+	case values.Count <= 1 || points <= 1:
+		return 1
+	}
+	return math.Max(0, 1-math.Log(float64(values.Count))/math.Log(float64(points)))
+}
+
+// seriesRows estimates the number of rows of each series of ms tracked by Suggest
+// as the number of points of its most frequent field.
+func (ms *measurementStats) seriesRows() map[string]int64 {
+	rows := make(map[string]int64)
+	for _, fs := range ms.fields {
+		for series, sv := range fs.tagLike.series {
+			if sv.points > rows[series] {
+				rows[series] = sv.points
+			}
+		}
+	}
+	return rows
+}
+
+// suggestion returns the Suggestion of field f of measurement ms identified by k.
+// seriesRows are the estimated numbers of rows of the series of ms.
+func (ms *measurementStats) suggestion(k sampleKey, f string, seriesRows map[string]int64) Suggestion {
+	fs := ms.fields[f]
+	var rows int64
+	for _, fs := range ms.fields {
+		if fs.points > rows {
+			rows = fs.points
+		}
+	}
+	tl := fs.tagLike
+	s := Suggestion{
+		Database:        k.db,
+		RetentionPolicy: k.rp,
+		Measurement:     k.measurement,
+		Field:           f,
+		Values:          tl.values.cardinality(),
+		Presence:        float64(fs.points) / float64(rows),
+		SeriesBefore:    ms.series.cardinality(),
+	}
+	typeScore := 1.0
+	for t := range fs.types {
+		s.Types = append(s.Types, t)
+		typeScore = math.Min(typeScore, typeScores[t])
+	}
+	sort.Strings(s.Types)
+
+	// series without the field and rows of series partially containing it keep their series key
+	constant, partial := 0, 0
+	for series, sv := range tl.series {
+		if !sv.changed {
+			constant++
+		}
+		if sv.points < seriesRows[series] {
+			partial++
+		}
+	}
+	s.Constancy = float64(constant) / float64(len(tl.series))
+	pairs := tl.pairs.cardinality()
+	s.SeriesAfter = Cardinality{
+		Count:     pairs.Count + s.SeriesBefore.Count - len(tl.series) + partial,
+		Truncated: pairs.Truncated || s.SeriesBefore.Truncated || tl.truncated,
+	}
+	s.Score = cardinalityWeight*cardinalityScore(s.Values, fs.points) +
+		typeWeight*typeScore +
+		constancyWeight*s.Constancy +
+		presenceWeight*s.Presence
+	return s
+}
+
+// Suggest reads the export or line protocol in r and ranks its fields by how tag-like they are.
+// Fields with few distinct values, string or boolean type, values constant within a series and presence in most rows
+// rank higher. The data is streamed, memory usage is bounded by the number of fields and conf.MaxValues.
+func Suggest(ctx context.Context, r io.Reader, conf SuggestConfig) ([]Suggestion, error) {
+	in := newInspector(InspectConfig{MaxValues: conf.MaxValues})
+	in.suggest = true
+	if err := readExport(ctx, r, func(db, rp string, p *Point) error {
+		in.measurement(db, rp, p.Measurement).add(p, in.conf.MaxValues)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to read input")
+	}
+
+	var ss []Suggestion
+	for _, k := range in.keys() {
+		ms := in.measurements[k]
+		rows := ms.seriesRows()
+		for f := range ms.fields {
+			ss = append(ss, ms.suggestion(k, f, rows))
+		}
+	}
+	sort.SliceStable(ss, func(i, j int) bool {
+		if ss[i].Score != ss[j].Score {
+			return ss[i].Score > ss[j].Score
+		}
+		if ss[i].Measurement != ss[j].Measurement {
+			return ss[i].Measurement < ss[j].Measurement
+		}
+		return ss[i].Field < ss[j].Field
+	})
+	if conf.Top > 0 && len(ss) > conf.Top {
+		ss = ss[:conf.Top]
+	}
+	return ss, nil
+}
+
+// WriteSuggestions writes ss to w in specified format, which is either "text" or "json".
+func WriteSuggestions(w io.Writer, ss []Suggestion, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(ss)
+	case "text", "":
+	default:
+		return errors.Errorf("unknown suggestions format '%s'", format)
+	}
+
+	var b strings.Builder
+	for i, s := range ss {
+		m := s.Measurement
+		if s.Database != "" {
+			m = s.Database + "." + s.RetentionPolicy + "." + m
+		}
+		fmt.Fprintf(&b, "%d. Field %s of %s: score %.2f\n", i+1, s.Field, m, s.Score)
+		fmt.Fprintf(&b, "\t%s, %s distinct values, constant in %.0f%% of series, present in %.0f%% of rows\n",
+			strings.Join(s.Types, ", "), s.Values, 100*s.Constancy, 100*s.Presence)
+		fmt.Fprintf(&b, "\tSeries: %s -> %s", s.SeriesBefore, s.SeriesAfter)
+		if !s.SeriesBefore.Truncated && !s.SeriesAfter.Truncated {
+			fmt.Fprintf(&b, " (%+d)", s.SeriesAfter.Count-s.SeriesBefore.Count)
+		}
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,64 @@
+package taggify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggest(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=a status="ok",value=1.5 1511629912071663075
+test,id=a status="ok",value=2.5 1511629912071663076
+test,id=b status="err",value=3.5 1511629912071663075
+test,id=b status="err",value=4.5 1511629912071663076
+test,id=b value=5.5 1511629912071663077`
+	input := strings.Join([]string{header, data, footer}, "\n")
+
+	ss, err := Suggest(context.Background(), strings.NewReader(input), SuggestConfig{})
+	if !a.NoError(err) || !a.Len(ss, 2) {
+		t.FailNow()
+	}
+	a.Equal("status", ss[0].Field)
+	a.Equal([]string{"string"}, ss[0].Types)
+	a.InDelta(0.81, ss[0].Score, 1e-9)
+	a.Equal(Cardinality{Count: 2}, ss[0].Values)
+	a.Equal(1.0, ss[0].Constancy)
+	a.InDelta(0.8, ss[0].Presence, 1e-9)
+	a.Equal(Cardinality{Count: 2}, ss[0].SeriesBefore)
+	a.Equal(Cardinality{Count: 3}, ss[0].SeriesAfter)
+
+	a.Equal("value", ss[1].Field)
+	a.InDelta(0.2, ss[1].Score, 1e-9)
+	a.Equal(0.0, ss[1].Constancy)
+	a.Equal(Cardinality{Count: 5}, ss[1].SeriesAfter)
+
+	out := &bytes.Buffer{}
+	a.NoError(WriteSuggestions(out, ss, "text"))
+	a.Equal(`1. Field status of test.autogen.test: score 0.81
+	string, 2 distinct values, constant in 100% of series, present in 80% of rows
+	Series: 2 -> 3 (+1)
+2. Field value of test.autogen.test: score 0.20
+	float, 5 distinct values, constant in 0% of series, present in 100% of rows
+	Series: 2 -> 5 (+3)
+`, out.String())
+
+	out.Reset()
+	a.NoError(WriteSuggestions(out, ss, "json"))
+	var decoded []Suggestion
+	a.NoError(json.Unmarshal(out.Bytes(), &decoded))
+	a.Equal(ss, decoded)
+	a.Error(WriteSuggestions(out, ss, "yaml"))
+
+	ss, err = Suggest(context.Background(), strings.NewReader(input), SuggestConfig{MaxValues: 1, Top: 1})
+	a.NoError(err)
+	if a.Len(ss, 1) {
+		a.Equal(Cardinality{Count: 1, Truncated: true}, ss[0].SeriesBefore)
+		a.True(ss[0].SeriesAfter.Truncated)
+	}
+}
@@ -0,0 +1,469 @@
+// Package taggify converts InfluxDB fields to tags by manipulating line protocol exported by influx_inspect.
+package taggify
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const startLine = "# writing tsm data"
+const stopLine = "# writing wal data"
+
+// Context lines of an export, which specify the database and retention policy of the data, which follows.
+const (
+	contextDatabase        = "# CONTEXT-DATABASE:"
+	contextRetentionPolicy = "# CONTEXT-RETENTION-POLICY:"
+)
+
+// parseContext updates db or rp if line is a context line.
+func parseContext(line string, db, rp *string) bool {
+	switch {
+	case strings.HasPrefix(line, contextDatabase):
+		*db = strings.TrimSpace(strings.TrimPrefix(line, contextDatabase))
+	case strings.HasPrefix(line, contextRetentionPolicy):
+		*rp = strings.TrimSpace(strings.TrimPrefix(line, contextRetentionPolicy))
+	default:
+		return false
+	}
+	return true
+}
+
+type stringWriter interface {
+	WriteString(string) (int, error)
+}
+
+func writeLine(w stringWriter, line string, appendNewline bool) error {
+	if appendNewline && len(line) > 1 && line[len(line)-1] != '\n' {
+		line += string('\n')
+	}
+
+	n, err := w.WriteString(line)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write line '%s'", line)
+	}
+	if n < len(line) {
+		return errors.Errorf("short write, wrote %d bytes instead of %d", n, len(line))
+	}
+	return nil
+}
+
+// ErrSkip may be returned by Hooks to drop the point.
+var ErrSkip = errors.New("skip point")
+
+// ctxCheckInterval is the number of lines processed between checks for context cancellation.
+const ctxCheckInterval = 4096
+
+// Hooks are called on points as they pass through Transform.
+// Hooks may modify the point or return ErrSkip to drop it, any other error aborts the transformation.
+type Hooks struct {
+	// Parsed, if not nil, is called for each line of the data section after it is parsed and before it is grouped.
+	Parsed func(*Point) error
+	// Grouped, if not nil, is called for each grouped row after the fields are promoted to tags and before it is written.
+	// Changes to the point are not reflected in Stats.
+	Grouped func(*Point) error
+}
+
+// Options configure the transformation performed by Transform.
+type Options struct {
+	// Fields are the names of the fields to convert to tags.
+	Fields []string
+	// MaxSeries is the maximum number of series the result may contain.
+	// Zero means no limit.
+	MaxSeries int
+	// DryRun, if set, disables writing of any output. Lines, which fail to parse,
+	// are recorded in Stats regardless of OnError.
+	DryRun bool
+	// OnError determines how lines in the data section, which fail to parse, are handled.
+	OnError ErrorPolicy
+	// Rejects, if not nil, is where lines, which fail to parse, are written to.
+	Rejects io.Writer
+	// MaxLineLength is the maximum length of a line in bytes. Zero means no limit.
+	// Longer lines in the data section are handled according to OnError.
+	MaxLineLength int
+	// Progress, if not nil, is updated as the transformation proceeds.
+	// Bytes read are not tracked, wrap the input in a ProgressReader for that.
+	Progress *Progress
+	// Hooks are called on points as they pass through Transform.
+	Hooks Hooks
+	// Transformers are applied in order to each grouped row after the Grouped hook.
+	// Changes made by transformers are not reflected in Stats.
+	Transformers []PointTransformer
+	// BatchTransformer, if not nil, is applied to batches of grouped rows after Transformers.
+	// Changes made by it are not reflected in Stats.
+	BatchTransformer BatchTransformer
+	// BatchSize is the number of points passed to BatchTransformer at once.
+	// Defaults to DefaultBatchSize.
+	BatchSize int
+	// Writer, if not nil, is where the result is written to instead of w. Database and retention policy
+	// of the points are taken from the context lines of the export. Comments and DDL are not written.
+	Writer Writer
+	// Checkpoint, if not nil, enables checkpointing of writes to Writer. The lines acknowledged according to it
+	// are skipped, so that a transformation of the same input interrupted after it was saved is resumed.
+	// Points are written in a deterministic order if it is set.
+	Checkpoint *Checkpoint
+	// InputID identifies the input if Checkpoint is set, e.g. by its size, modification time and a hash of its contents.
+	// A checkpoint created for a different input is refused.
+	InputID string
+	// CheckpointInterval is the number of lines written to Writer between checkpoints.
+	// Defaults to DefaultCheckpointInterval.
+	CheckpointInterval int
+	// OnCheckpoint, if not nil, is called with a new checkpoint each time all lines written so far are acknowledged by Writer.
+	OnCheckpoint func(Checkpoint) error
+}
+
+// promote converts the fields of p with given names to tags.
+func promote(p *Point, names []string) {
+	for _, name := range names {
+		if v, ok := p.Field(name); ok {
+			p.DeleteField(name)
+			p.SetTag(name, FormatValue(v))
+		}
+	}
+}
+
+// callHook calls hook on p, if hook is not nil. It reports whether p should be kept.
+func callHook(hook func(*Point) error, p *Point) (bool, error) {
+	if hook == nil {
+		return true, nil
+	}
+	switch err := hook(p); err {
+	case nil:
+		return true, nil
+	case ErrSkip:
+		return false, nil
+	default:
+		return false, errors.Wrap(err, "hook failed")
+	}
+}
+
+// handleParseError records perr for line in st and rejects. It returns a non-nil error
+// if the transformation should be aborted according to opts.
+func handleParseError(st *Stats, rejects *rejectWriter, opts *Options, line string, perr *ParseError) error {
+	st.parseError(perr)
+	if rejects != nil {
+		if err := rejects.reject(line, perr); err != nil {
+			return err
+		}
+	}
+	if !opts.DryRun && !opts.OnError.tolerates(st.ParseErrors) {
+		if opts.OnError.MaxErrors > 0 {
+			return errors.Wrapf(perr, "too many errors (%d)", st.ParseErrors)
+		}
+		return perr
+	}
+	return nil
+}
+
+// emitRow promotes the fields of a grouped row p to tags, applies the Grouped hook and transformers
+// configured in opts and passes the result to e.
+func emitRow(ctx context.Context, e *emitter, p *Point, opts *Options) error {
+	promote(p, opts.Fields)
+	if ok, err := callHook(opts.Hooks.Grouped, p); err != nil {
+		return err
+	} else if !ok {
+		return nil
+	}
+	points, err := applyTransformers(opts.Transformers, p)
+	if err != nil {
+		return err
+	}
+	return e.emit(ctx, points...)
+}
+
+// group merges p with series key key into entries.
+func group(entries map[string]map[int64]*Point, key string, p *Point, progress *Progress) {
+	// by measurement+tags
+	rows, ok := entries[key]
+	if !ok {
+		rows = make(map[int64]*Point)
+		entries[key] = rows
+		progress.grow(len(key) + seriesOverhead)
+	}
+
+	// by timestamp
+	row, ok := rows[p.Time]
+	if !ok {
+		rows[p.Time] = p
+		progress.grow(rowOverhead)
+		for _, f := range p.Fields {
+			progress.grow(len(f.Key) + fieldOverhead)
+		}
+		return
+	}
+	for _, f := range p.Fields {
+		if _, ok := row.Field(f.Key); !ok {
+			progress.grow(len(f.Key) + fieldOverhead)
+		}
+		row.SetField(f.Key, f.Value)
+	}
+}
+
+// emitEntries passes the rows in entries to emitRow, ordered by series key and timestamp if opts.Checkpoint is set,
+// since resuming relies on the order being the same on each run.
+func emitEntries(ctx context.Context, e *emitter, entries map[string]map[int64]*Point, opts *Options) error {
+	if opts.Checkpoint != nil {
+		return emitSorted(ctx, e, entries, opts)
+	}
+	for _, rows := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, p := range rows {
+			if err := emitRow(ctx, e, p, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// emitSorted passes the rows in entries to emitRow ordered by series key and timestamp.
+func emitSorted(ctx context.Context, e *emitter, entries map[string]map[int64]*Point, opts *Options) error {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var times []int64
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rows := entries[key]
+		times = times[:0]
+		for t := range rows {
+			times = append(times, t)
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		for _, t := range times {
+			if err := emitRow(ctx, e, rows[t], opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Transform reads an export produced by influx_inspect from r, converts fields specified in opts to tags
+// and writes the result to w.
+func Transform(ctx context.Context, r io.Reader, w io.Writer, opts Options) (st Stats, err error) {
+	if opts.DryRun || opts.Writer != nil {
+		w = ioutil.Discard
+	}
+	buf := bufio.NewWriter(w)
+	defer func() {
+		if ferr := buf.Flush(); ferr != nil && err == nil {
+			err = errors.Wrap(ferr, "failed to write data")
+		}
+	}()
+
+	var rejects *rejectWriter
+	if opts.Rejects != nil {
+		rejects = newRejectWriter(opts.Rejects)
+		defer func() {
+			if ferr := rejects.Flush(); ferr != nil && err == nil {
+				err = errors.Wrap(ferr, "failed to write rejected lines")
+			}
+		}()
+	}
+
+	// offset is the input offset of the data written last to opts.Writer.
+	var offset int64
+	var rw *resumeWriter
+	if opts.Writer != nil && opts.Checkpoint != nil && !opts.DryRun {
+		rw, err = newResumeWriter(opts.Writer, *opts.Checkpoint, opts.Fields, opts.InputID, opts.CheckpointInterval, &offset, opts.OnCheckpoint)
+		if err != nil {
+			return st, err
+		}
+		opts.Writer = rw
+	}
+
+	sc := newLineReader(r, opts.MaxLineLength)
+
+	// header is only written once the data section is processed,
+	// so that nothing is output if the transformation is aborted.
+	var header []string
+	var db, rp string
+	nextSection := false
+	opts.Progress.setSection(SectionHeader)
+	for sc.Scan() {
+		st.LinesRead++
+		opts.Progress.addLine()
+		if sc.tooLong {
+			return st, sc.lineError(SectionHeader)
+		}
+		header = append(header, sc.Text())
+		parseContext(sc.Text(), &db, &rp)
+		if strings.HasPrefix(sc.Text(), startLine) {
+			nextSection = true
+			break
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return st, errors.Wrap(err, "failed to read input")
+	}
+	if !nextSection {
+		return st, errors.New("unexpected end of input while reading header section")
+	}
+	nextSection = false
+	offset = sc.next
+
+	// skipData is set if the data section is acknowledged according to the checkpoint,
+	// in which case the lines are skipped by offset instead of by number.
+	skipData := rw != nil && opts.Checkpoint.Offset > offset
+	if skipData {
+		rw.skip = 0
+	}
+
+	// measurement[,tag1=value1,tag2=value=2...] -> timestamp -> point
+	entries := make(map[string]map[int64]*Point)
+	opts.Progress.setSection(SectionTSM)
+	for sc.Scan() {
+		st.LinesRead++
+		opts.Progress.addLine()
+		if sc.n%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return st, err
+			}
+		}
+		if skipData && !strings.HasPrefix(sc.Text(), stopLine) {
+			continue
+		}
+		var (
+			key  string
+			p    *Point
+			perr *ParseError
+			err  error
+		)
+		if sc.tooLong {
+			perr = sc.lineError(SectionTSM)
+		} else if strings.HasPrefix(sc.Text(), stopLine) {
+			nextSection = true
+			break
+		} else if key, p, err = parsePoint(sc.Bytes()); err != nil {
+			perr = err.(*ParseError)
+			perr.Section = SectionTSM.String()
+			perr.Line = sc.n
+			perr.Offset = sc.offset
+		}
+		if perr != nil {
+			if err := handleParseError(&st, rejects, &opts, sc.Text(), perr); err != nil {
+				return st, err
+			}
+			continue
+		}
+		if opts.Hooks.Parsed != nil {
+			if ok, err := callHook(opts.Hooks.Parsed, p); err != nil {
+				return st, err
+			} else if !ok {
+				continue
+			}
+			key = p.SeriesKey()
+		}
+
+		group(entries, key, p, opts.Progress)
+	}
+	if err = sc.Err(); err != nil {
+		return st, errors.Wrap(err, "failed to read input")
+	}
+	if !nextSection {
+		return st, errors.New("unexpected end of input while reading data section")
+	}
+	nextSection = false
+	dataEnd := sc.offset
+
+	st.collect([]map[string]map[int64]*Point{entries}, opts.Fields)
+	if err := st.checkMaxSeries(opts.MaxSeries); err != nil {
+		return st, err
+	}
+
+	for _, line := range header {
+		if err := writeLine(buf, line, true); err != nil {
+			return st, err
+		}
+	}
+
+	if !opts.DryRun {
+		e := &emitter{
+			w:         buf,
+			batch:     opts.BatchTransformer,
+			batchSize: opts.BatchSize,
+			progress:  opts.Progress,
+			dest:      opts.Writer,
+			db:        db,
+			rp:        rp,
+		}
+		if err := emitEntries(ctx, e, entries, &opts); err != nil {
+			return st, err
+		}
+		if err := e.flush(ctx); err != nil {
+			return st, err
+		}
+	}
+	offset = dataEnd
+
+	opts.Progress.setSection(SectionWAL)
+	last, newline := sc.Text(), sc.newline
+	for sc.Scan() {
+		st.LinesRead++
+		opts.Progress.addLine()
+		if sc.n%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return st, err
+			}
+		}
+		if sc.tooLong {
+			return st, sc.lineError(SectionWAL)
+		}
+		if opts.Writer != nil {
+			if opts.DryRun {
+				continue
+			}
+			if skipData && sc.next <= opts.Checkpoint.Offset {
+				parseContext(sc.Text(), &db, &rp)
+				continue
+			}
+			offset = sc.next
+			if err := writeRaw(ctx, opts.Writer, sc.Text(), &db, &rp); err != nil {
+				return st, err
+			}
+			continue
+		}
+		if err := writeLine(buf, last, true); err != nil {
+			return st, err
+		}
+		last, newline = sc.Text(), sc.newline
+	}
+	if err = sc.Err(); err != nil {
+		return st, errors.Wrap(err, "failed to read input")
+	}
+	if opts.Writer != nil {
+		if opts.DryRun {
+			return st, nil
+		}
+		if skipData && sc.next < opts.Checkpoint.Offset {
+			return st, errors.Errorf("input ended at offset %d before the checkpoint at offset %d, it does not match the input", sc.next, opts.Checkpoint.Offset)
+		}
+		return st, opts.Writer.Flush(ctx)
+	}
+	return st, writeLine(buf, last, newline)
+}
+
+// writeRaw writes a line following the data section of an export to w as is,
+// unless it is a comment or empty. Context lines update db and rp.
+func writeRaw(ctx context.Context, w Writer, line string, db, rp *string) error {
+	if parseContext(line, db, rp) {
+		return nil
+	}
+	if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+		return nil
+	}
+	return w.WriteLine(ctx, *db, *rp, []byte(line))
+}
@@ -0,0 +1,353 @@
+package taggify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	header = `# INFLUXDB EXPORT: 1677-09-21T00:32:15+00:19 - 2262-04-12T00:47:16+01:00
+# DDL
+CREATE DATABASE test WITH NAME test
+# DML
+# CONTEXT-DATABASE:test
+# CONTEXT-RETENTION-POLICY:autogen
+# writing tsm data`
+
+	footer = `# writing wal data`
+)
+
+func TestTaggify(t *testing.T) {
+	for _, tc := range []struct {
+		data   string
+		result map[string]bool
+	}{
+		{
+			`test,id=foo string="foo" 1511629912071663075
+test,id=foo string="foo" 1511629912071663075
+test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=42 1511629912071663075
+test,id=foo idd="bar" 1511629912071663076
+test,id=foo int=43 1511629912071663076
+test,id=foo,idd=already string="foo" 1511629912071663075`,
+			map[string]bool{
+				`test,id=foo,idd=bar string="foo" 1511629912071663075`:     true,
+				`test,id=foo,idd=bar int=42 1511629912071663075`:           true,
+				`test,id=foo,idd=bar int=43 1511629912071663076`:           true,
+				`test,id=foo,idd=already string="foo" 1511629912071663075`: true,
+			},
+		},
+	} {
+		a := assert.New(t)
+		buf := &bytes.Buffer{}
+
+		_, err := Transform(context.Background(), strings.NewReader(strings.Join([]string{header, tc.data, footer}, string('\n'))), buf, Options{
+			Fields: []string{"idd", "non-existant"},
+		})
+		a.NoError(err)
+
+		out := buf.String()
+		if !a.True(len(out) > len(header)+len(footer), "length of output") {
+			t.Fatal(out)
+		}
+		if !a.Equal(header, out[:len(header)], "header") {
+			t.Fatal(out[:len(header)])
+		}
+		if !a.Equal(footer, out[len(out)-len(footer):], "footer") {
+			t.Fatal(out[len(out)-len(footer):])
+		}
+
+		sc := bufio.NewScanner(strings.NewReader(out[len(header)+1 : len(out)-len(footer)-1]))
+		seen := make(map[string]int, len(tc.result))
+		for sc.Scan() {
+			a.Contains(tc.result, sc.Text(), "Unexpected line: %s", sc.Text())
+			seen[sc.Text()]++
+		}
+		a.NoError(sc.Err())
+		for line, i := range seen {
+			a.Equalf(1, i, "Times '%s' is outputed", line)
+		}
+	}
+}
+
+func TestTaggifyMaxSeries(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=42 1511629912071663075
+test,id=foo idd="baz" 1511629912071663076
+test,id=foo int=43 1511629912071663076`
+
+	for _, tc := range []struct {
+		max int
+		ok  bool
+	}{
+		{0, true},
+		{2, true},
+		{1, false},
+	} {
+		buf := &bytes.Buffer{}
+		st, err := Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), buf, Options{
+			Fields:    []string{"idd"},
+			MaxSeries: tc.max,
+		})
+		if tc.ok {
+			a.NoError(err)
+			a.NotZero(buf.Len())
+		} else {
+			a.Error(err)
+			a.Zero(buf.Len(), "output written despite exceeding the series limit")
+		}
+		a.Equal(map[string]int{"test": 1}, st.SeriesBefore)
+		a.Equal(map[string]int{"test": 2}, st.SeriesAfter)
+		a.Equal(map[string]int{"idd": 2}, st.FieldValues)
+	}
+}
+
+func TestTaggifyDryRun(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=42,float=4.2 1511629912071663075
+test,id=foo int=43 1511629912071663076
+test,id=foo,idd=already int=44 1511629912071663076
+test,id=foo int=`
+
+	buf := &bytes.Buffer{}
+	st, err := Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), buf, Options{
+		Fields: []string{"idd"},
+		DryRun: true,
+	})
+	a.NoError(err)
+	a.Zero(buf.Len(), "output written in dry-run mode")
+	a.Equal(13, st.LinesRead)
+	a.Equal(4, st.PointsEmitted)
+	a.Equal(1, st.ParseErrors)
+	a.Len(st.Errors, 1)
+	a.Equal(1, st.SeriesCreated)
+	a.Equal(map[string]int{"test": 2}, st.SeriesBefore)
+	a.Equal(map[string]int{"test": 3}, st.SeriesAfter)
+	a.Equal(map[string]map[string]int{"test": {"idd": 1}}, st.Promoted)
+	a.Equal(map[string]map[string]int{"test": {"idd": 2}}, st.Missing)
+
+	out := &bytes.Buffer{}
+	a.NoError(WriteReport(out, &st, "json"))
+	a.Contains(out.String(), `"points_emitted": 4`)
+}
+
+func TestTaggifyProgress(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=42 1511629912071663075`
+	in := strings.Join([]string{header, data, footer}, string('\n'))
+
+	p := &Progress{}
+	_, err := Transform(context.Background(), ProgressReader{Reader: strings.NewReader(in), Progress: p}, &bytes.Buffer{}, Options{
+		Fields:   []string{"idd"},
+		Progress: p,
+	})
+	a.NoError(err)
+	a.Equal(int64(len(in)), p.bytes)
+	a.Equal(int64(10), p.lines)
+	a.Equal(int64(1), p.points)
+	a.Equal(int32(SectionWAL), p.section)
+	a.NotZero(p.mapSize)
+	a.Contains(p.Line(2*int64(len(in)), time.Second), "(50.0%)")
+	a.Contains(p.Line(2*int64(len(in)), time.Second), "ETA 1s")
+}
+
+func TestTaggifyOnError(t *testing.T) {
+	data := `test,id=foo idd="bar" 1511629912071663075
+test,id=foo int= 1511629912071663075
+test,id=foo int=42 1511629912071663075
+test,id=foo int=43,idd 1511629912071663076`
+
+	for _, tc := range []struct {
+		policy  string
+		ok      bool
+		rejects string
+	}{
+		{
+			policy: "abort",
+			rejects: `# line=9 offset=241 column=16 error=missing field value
+test,id=foo int= 1511629912071663075
+`,
+		},
+		{
+			policy: "max-errors=1",
+			rejects: `# line=9 offset=241 column=16 error=missing field value
+test,id=foo int= 1511629912071663075
+# line=11 offset=317 column=23 error=invalid field format
+test,id=foo int=43,idd 1511629912071663076
+`,
+		},
+		{
+			policy: "skip",
+			ok:     true,
+			rejects: `# line=9 offset=241 column=16 error=missing field value
+test,id=foo int= 1511629912071663075
+# line=11 offset=317 column=23 error=invalid field format
+test,id=foo int=43,idd 1511629912071663076
+`,
+		},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			a := assert.New(t)
+
+			var policy ErrorPolicy
+			if !a.NoError(policy.Set(tc.policy)) {
+				t.FailNow()
+			}
+			a.Equal(tc.policy, policy.String())
+
+			out := &bytes.Buffer{}
+			rejects := &bytes.Buffer{}
+			st, err := Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), out, Options{
+				Fields:  []string{"idd"},
+				OnError: policy,
+				Rejects: rejects,
+			})
+			if tc.ok {
+				a.NoError(err)
+				a.Contains(out.String(), "test,id=foo,idd=bar int=42 1511629912071663075")
+			} else {
+				a.Error(err)
+				a.Zero(out.Len())
+			}
+			a.Equal(tc.rejects, rejects.String())
+			a.Equal(strings.Count(tc.rejects, "# line="), st.ParseErrors)
+		})
+	}
+}
+
+func TestTaggifyParseError(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=4.2.1 1511629912071663075`
+
+	_, err := Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), &bytes.Buffer{}, Options{
+		Fields: []string{"idd"},
+	})
+	var perr *ParseError
+	if !a.True(errors.As(err, &perr), "error is not a *ParseError: %v", err) {
+		t.FailNow()
+	}
+	a.Equal("tsm", perr.Section)
+	a.Equal(9, perr.Line)
+	a.Equal(int64(241), perr.Offset)
+	a.Equal(20, perr.Column)
+	a.Equal("int=4.2.1", perr.Excerpt)
+	a.Equal(ErrInvalidNumber, perr.Err)
+	a.Equal(`tsm section, line 9, column 20, offset 241: invalid number near "int=4.2.1"`, err.Error())
+}
+
+func TestExcerpt(t *testing.T) {
+	a := assert.New(t)
+
+	long := strings.Repeat("x", 2*maxExcerptLength)
+	for _, tc := range []struct {
+		line     string
+		pos      int
+		expected string
+	}{
+		{"cpu,host=a value=1 1", 0, "cpu"},
+		{"cpu,host=a value=1 1", 6, "host=a"},
+		{`cpu,ho\ st=a value=1 1`, 4, `ho\ st=a`},
+		{"cpu,host=a value=1 1", 20, "1"},
+		{"cpu value=" + long + " 1", 10 + maxExcerptLength, long[:maxExcerptLength]},
+	} {
+		a.Equal(tc.expected, excerpt([]byte(tc.line), tc.pos), "line %q at %d", tc.line, tc.pos)
+	}
+}
+
+func TestTaggifyLongLines(t *testing.T) {
+	a := assert.New(t)
+
+	long := `test,id=foo str="` + strings.Repeat("x", 1<<20) + `" 1511629912071663075`
+	data := `test,id=foo idd="bar" 1511629912071663075
+` + long
+
+	out := &bytes.Buffer{}
+	_, err := Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), out, Options{
+		Fields: []string{"idd"},
+	})
+	a.NoError(err)
+	a.Contains(out.String(), "test,id=foo,idd=bar "+long[len("test,id=foo "):])
+
+	rejects := &bytes.Buffer{}
+	st, err := Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), &bytes.Buffer{}, Options{
+		Fields:        []string{"idd"},
+		MaxLineLength: 1024,
+		OnError:       SkipOnError,
+		Rejects:       rejects,
+	})
+	a.NoError(err)
+	a.Equal(1, st.ParseErrors)
+	a.Contains(rejects.String(), "# line=9 offset=241 column=1025 error=line too long, exceeds 1024 bytes\n")
+
+	_, err = Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), &bytes.Buffer{}, Options{
+		Fields:        []string{"idd"},
+		MaxLineLength: 1024,
+	})
+	a.True(errors.Is(err, ErrLineTooLong), "unexpected error: %v", err)
+}
+
+func TestTransformHooks(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=42 1511629912071663075
+test,id=foo int=43 1511629912071663076
+dropped,id=foo int=44 1511629912071663076`
+
+	var parsed, grouped int
+	out := &bytes.Buffer{}
+	_, err := Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), out, Options{
+		Fields: []string{"idd"},
+		Hooks: Hooks{
+			Parsed: func(p *Point) error {
+				parsed++
+				if p.Measurement == "dropped" {
+					return ErrSkip
+				}
+				return nil
+			},
+			Grouped: func(p *Point) error {
+				grouped++
+				if v, ok := p.Field("int"); ok {
+					p.SetField("copy", v)
+				}
+				return nil
+			},
+		},
+	})
+	a.NoError(err)
+	a.Equal(4, parsed)
+	a.Equal(2, grouped)
+	a.NotContains(out.String(), "dropped")
+	a.Contains(out.String(), "test,id=foo,idd=bar copy=42 1511629912071663075")
+	a.Contains(out.String(), "test,id=foo copy=43 1511629912071663076")
+
+	_, err = Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), &bytes.Buffer{}, Options{
+		Hooks: Hooks{
+			Parsed: func(p *Point) error {
+				return errors.New("test")
+			},
+		},
+	})
+	a.EqualError(err, "hook failed: test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Transform(ctx, strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), &bytes.Buffer{}, Options{})
+	a.Equal(context.Canceled, err)
+}
@@ -0,0 +1,150 @@
+package taggify
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// PointTransformer transforms grouped rows before they are written.
+type PointTransformer interface {
+	// Transform is called with each grouped row after the fields are promoted to tags.
+	// It may modify p and returns the points to write in its place: p itself, additional points or none to drop it.
+	Transform(p *Point) ([]*Point, error)
+}
+
+// TransformerFunc is an adapter to allow the use of ordinary functions as PointTransformer.
+type TransformerFunc func(p *Point) ([]*Point, error)
+
+// Transform calls f(p).
+func (f TransformerFunc) Transform(p *Point) ([]*Point, error) {
+	return f(p)
+}
+
+// applyTransformers applies ts in order to p and returns the resulting points.
+func applyTransformers(ts []PointTransformer, p *Point) ([]*Point, error) {
+	points := []*Point{p}
+	for _, t := range ts {
+		var out []*Point
+		for _, p := range points {
+			res, err := t.Transform(p)
+			if err == ErrSkip {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "transformer failed")
+			}
+			out = append(out, res...)
+		}
+		points = out
+	}
+	return points, nil
+}
+
+// NewTransformerFunc constructs a PointTransformer from arg.
+type NewTransformerFunc func(arg string) (PointTransformer, error)
+
+var transformers = struct {
+	sync.RWMutex
+	m map[string]NewTransformerFunc
+}{
+	m: make(map[string]NewTransformerFunc),
+}
+
+// RegisterTransformer makes a PointTransformer constructor available under name.
+// It is intended to be called from init functions and panics if name is already registered.
+func RegisterTransformer(name string, f NewTransformerFunc) {
+	transformers.Lock()
+	defer transformers.Unlock()
+	if _, ok := transformers.m[name]; ok {
+		panic("transformer " + name + " is already registered")
+	}
+	transformers.m[name] = f
+}
+
+// RegisteredTransformers returns the sorted names of registered transformers.
+func RegisteredTransformers() []string {
+	transformers.RLock()
+	defer transformers.RUnlock()
+	names := make([]string, 0, len(transformers.m))
+	for name := range transformers.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTransformer constructs a registered PointTransformer from spec of form 'name[:arg]'.
+func NewTransformer(spec string) (PointTransformer, error) {
+	name, arg := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		name, arg = spec[:i], spec[i+1:]
+	}
+	transformers.RLock()
+	f, ok := transformers.m[name]
+	transformers.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("unknown transformer '%s'", name)
+	}
+	t, err := f(arg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create transformer '%s'", name)
+	}
+	return t, nil
+}
+
+func init() {
+	RegisterTransformer("derive-tag", func(arg string) (PointTransformer, error) {
+		i := strings.IndexByte(arg, '=')
+		if i <= 0 || i == len(arg)-1 {
+			return nil, errors.Errorf("invalid argument '%s', must be of form 'tag=field1[,field2...]'", arg)
+		}
+		return &DeriveTag{
+			Tag:    arg[:i],
+			Fields: strings.Split(arg[i+1:], ","),
+		}, nil
+	})
+}
+
+// DefaultDeriveTagSeparator is the separator used by DeriveTag if none is specified.
+const DefaultDeriveTagSeparator = "."
+
+// DeriveTag is a PointTransformer, which sets tag Tag to the values of Fields joined by Separator.
+// Points, which miss any of the fields, are left unchanged.
+// It is registered as 'derive-tag:tag=field1[,field2...]'.
+type DeriveTag struct {
+	Tag    string
+	Fields []string
+	// Separator is the separator of the values. Defaults to DefaultDeriveTagSeparator.
+	Separator string
+	// Delete, if set, deletes the fields the tag is derived from.
+	Delete bool
+}
+
+// Transform implements PointTransformer.
+func (t *DeriveTag) Transform(p *Point) ([]*Point, error) {
+	sep := t.Separator
+	if sep == "" {
+		sep = DefaultDeriveTagSeparator
+	}
+	values := make([]string, len(t.Fields))
+	for i, name := range t.Fields {
+		v, ok := p.Field(name)
+		if !ok {
+			return []*Point{p}, nil
+		}
+		values[i] = FormatValue(v)
+	}
+	p.SetTag(t.Tag, strings.Join(values, sep))
+	if t.Delete {
+		for _, name := range t.Fields {
+			p.DeleteField(name)
+		}
+		if len(p.Fields) == 0 {
+			return nil, nil
+		}
+	}
+	return []*Point{p}, nil
+}
@@ -0,0 +1,108 @@
+package taggify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveTag(t *testing.T) {
+	a := assert.New(t)
+
+	tr, err := NewTransformer("derive-tag:location=dc,rack")
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	a.Equal(&DeriveTag{Tag: "location", Fields: []string{"dc", "rack"}}, tr)
+
+	p, err := ParsePoint([]byte(`test,id=foo dc="ams",rack=4i,value=1 1`))
+	a.NoError(err)
+	points, err := tr.Transform(p)
+	a.NoError(err)
+	if a.Len(points, 1) {
+		a.Equal(`test,id=foo,location=ams.4 dc="ams",rack=4i,value=1 1`, points[0].String())
+	}
+
+	p, err = ParsePoint([]byte(`test,id=foo dc="ams",value=1 1`))
+	a.NoError(err)
+	points, err = tr.Transform(p)
+	a.NoError(err)
+	if a.Len(points, 1) {
+		a.Equal(`test,id=foo dc="ams",value=1 1`, points[0].String())
+	}
+
+	tr = &DeriveTag{Tag: "location", Fields: []string{"dc", "rack"}, Separator: "/", Delete: true}
+	p, err = ParsePoint([]byte(`test dc="ams",rack=4i,value=1 1`))
+	a.NoError(err)
+	points, err = tr.Transform(p)
+	a.NoError(err)
+	if a.Len(points, 1) {
+		a.Equal(`test,location=ams/4 value=1 1`, points[0].String())
+	}
+
+	p, err = ParsePoint([]byte(`test dc="ams",rack=4i 1`))
+	a.NoError(err)
+	points, err = tr.Transform(p)
+	a.NoError(err)
+	a.Empty(points)
+
+	for _, spec := range []string{"unknown", "derive-tag", "derive-tag:tag", "derive-tag:=field", "derive-tag:tag="} {
+		_, err := NewTransformer(spec)
+		a.Error(err, "spec %q", spec)
+	}
+	a.Contains(RegisteredTransformers(), "derive-tag")
+}
+
+func TestTransformTransformers(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=42 1511629912071663075
+test,id=foo int=43 1511629912071663076
+test,id=foo pair="1:2" 1511629912071663077
+dropped,id=foo int=44 1511629912071663076`
+
+	out := &bytes.Buffer{}
+	_, err := Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), out, Options{
+		Fields: []string{"idd"},
+		Transformers: []PointTransformer{
+			TransformerFunc(func(p *Point) ([]*Point, error) {
+				if p.Measurement == "dropped" {
+					return nil, nil
+				}
+				v, ok := p.Field("pair")
+				if !ok {
+					return []*Point{p}, nil
+				}
+				// split the field into two points
+				parts := strings.SplitN(v.(string), ":", 2)
+				p.DeleteField("pair")
+				p.SetField("left", parts[0])
+				q := p.Copy()
+				q.Measurement = "split"
+				q.Fields = []Field{{Key: "right", Value: parts[1]}}
+				return []*Point{p, q}, nil
+			}),
+			&DeriveTag{Tag: "derived", Fields: []string{"int"}},
+		},
+	})
+	a.NoError(err)
+	a.NotContains(out.String(), "dropped")
+	a.Contains(out.String(), "test,derived=42,id=foo,idd=bar int=42 1511629912071663075\n")
+	a.Contains(out.String(), "test,derived=43,id=foo int=43 1511629912071663076\n")
+	a.Contains(out.String(), `test,id=foo left="1" 1511629912071663077`+"\n")
+	a.Contains(out.String(), `split,id=foo right="2" 1511629912071663077`+"\n")
+
+	_, err = Transform(context.Background(), strings.NewReader(strings.Join([]string{header, data, footer}, string('\n'))), &bytes.Buffer{}, Options{
+		Transformers: []PointTransformer{
+			TransformerFunc(func(p *Point) ([]*Point, error) {
+				return nil, errors.New("test")
+			}),
+		},
+	})
+	a.EqualError(err, "transformer failed: test")
+}
@@ -0,0 +1,394 @@
+package taggify
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+	"github.com/pkg/errors"
+)
+
+// FilesConfig selects the data read by TransformFiles.
+type FilesConfig struct {
+	// DataDir is the data directory of InfluxDB, containing TSM files in <database>/<retention policy>/<shard> directories.
+	DataDir string
+	// WALDir is the WAL directory of InfluxDB, with the same layout as DataDir.
+	WALDir string
+	// Database, if not empty, is the only database read.
+	Database string
+	// RetentionPolicy, if not empty, is the only retention policy read. Requires Database.
+	RetentionPolicy string
+	// Start and End, if not zero, limit the time range of the read data, both inclusive.
+	Start, End time.Time
+}
+
+// timeRange returns the time range between start and end in nanoseconds, unbounded where they are zero.
+func timeRange(start, end time.Time) (int64, int64, error) {
+	min, max := int64(math.MinInt64), int64(math.MaxInt64)
+	if !start.IsZero() {
+		min = start.UnixNano()
+	}
+	if !end.IsZero() {
+		max = end.UnixNano()
+	}
+	if max < min {
+		return 0, 0, errors.New("end time is before start time")
+	}
+	return min, max, nil
+}
+
+// shardFiles are the TSM and WAL files of a retention policy.
+type shardFiles struct {
+	dbrp
+	tsm, wal []string
+}
+
+// walkFiles passes each file with extension ext in dir selected by conf to add along with the files of its retention policy.
+func walkFiles(conf FilesConfig, dir, ext string, files map[dbrp]*shardFiles, add func(*shardFiles, string)) error {
+	if dir == "" {
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || filepath.Ext(path) != "."+ext {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		dirs := strings.Split(rel, string(os.PathSeparator))
+		if len(dirs) < 3 {
+			return errors.Errorf("invalid directory structure for %s", path)
+		}
+		if conf.Database != "" && dirs[0] != conf.Database ||
+			conf.RetentionPolicy != "" && dirs[1] != conf.RetentionPolicy {
+			return nil
+		}
+		k := dbrp{db: dirs[0], rp: dirs[1]}
+		sf, ok := files[k]
+		if !ok {
+			sf = &shardFiles{dbrp: k}
+			files[k] = sf
+		}
+		add(sf, path)
+		return nil
+	})
+}
+
+// readTSMFile groups the values in the TSM file at path within [start, end] into entries.
+func readTSMFile(ctx context.Context, path string, start, end int64, entries map[string]map[int64]*Point, st *Stats, opts *Options) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer f.Close()
+
+	r, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", path)
+	}
+	defer r.Close()
+
+	if min, max := r.TimeRange(); min > end || max < start {
+		return nil
+	}
+	for i := 0; i < r.KeyCount(); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key, _ := r.KeyAt(i)
+		values, err := r.ReadAll(key)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read key %q in %s", key, path)
+		}
+		if err := groupValues(key, values, start, end, entries, st, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readWALFile applies the writes and deletes in the WAL segment at path within [start, end] to entries.
+// A corrupt entry is treated as the end of the segment, as InfluxDB does when it loads the WAL.
+func readWALFile(ctx context.Context, path string, start, end int64, entries map[string]map[int64]*Point, st *Stats, opts *Options) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer f.Close()
+
+	r := tsm1.NewWALSegmentReader(f)
+	defer r.Close()
+	for r.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry, err := r.Read()
+		if err != nil {
+			break
+		}
+		switch e := entry.(type) {
+		case *tsm1.WriteWALEntry:
+			// sort the keys, so that the result does not depend on map iteration order
+			keys := make([]string, 0, len(e.Values))
+			for key := range e.Values {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if err := groupValues([]byte(key), e.Values[key], start, end, entries, st, opts); err != nil {
+					return err
+				}
+			}
+		case *tsm1.DeleteWALEntry:
+			for _, key := range e.Keys {
+				deleteValues(key, math.MinInt64, math.MaxInt64, entries)
+			}
+		case *tsm1.DeleteRangeWALEntry:
+			for _, key := range e.Keys {
+				deleteValues(key, e.Min, e.Max, entries)
+			}
+		}
+	}
+	return nil
+}
+
+// groupValues groups values of the series and field in composite key within [start, end] into entries.
+func groupValues(key []byte, values []tsm1.Value, start, end int64, entries map[string]map[int64]*Point, st *Stats, opts *Options) error {
+	series, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+	seriesKey := string(series)
+	measurement, tags := parseKey(series)
+	for _, v := range values {
+		t := v.UnixNano()
+		if t < start || t > end {
+			continue
+		}
+		st.LinesRead++
+		opts.Progress.addLine()
+
+		p := &Point{
+			Measurement: measurement,
+			Tags:        tags,
+			Fields:      []Field{{Key: string(field), Value: v.Value()}},
+			Time:        t,
+		}
+		key := seriesKey
+		if opts.Hooks.Parsed != nil {
+			// the tags are shared by all values of the series
+			p.Tags = append([]Tag(nil), tags...)
+			if ok, err := callHook(opts.Hooks.Parsed, p); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			key = p.SeriesKey()
+		}
+		group(entries, key, p, opts.Progress)
+	}
+	return nil
+}
+
+// deleteValues deletes the values of the series and field in composite key within [min, max] from entries.
+func deleteValues(key []byte, min, max int64, entries map[string]map[int64]*Point) {
+	series, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+	rows, ok := entries[string(series)]
+	if !ok {
+		return
+	}
+	for t, p := range rows {
+		if t < min || t > max {
+			continue
+		}
+		p.DeleteField(string(field))
+		if len(p.Fields) == 0 {
+			delete(rows, t)
+		}
+	}
+	if len(rows) == 0 {
+		delete(entries, string(series))
+	}
+}
+
+// TransformFiles reads the TSM and WAL files of InfluxDB selected by conf, converts fields specified in opts to tags
+// and writes the result to w in the format of influx_inspect export, or to opts.Writer, if it is set.
+// The data of each database and retention policy is grouped separately, data in the WAL takes precedence over TSM files.
+// Options related to parsing and the input offset are ignored.
+func TransformFiles(ctx context.Context, conf FilesConfig, w io.Writer, opts Options) (st Stats, err error) {
+	if conf.RetentionPolicy != "" && conf.Database == "" {
+		return st, errors.New("database must be specified to select a retention policy")
+	}
+	start, end, err := timeRange(conf.Start, conf.End)
+	if err != nil {
+		return st, err
+	}
+
+	files := make(map[dbrp]*shardFiles)
+	if err := walkFiles(conf, conf.DataDir, tsm1.TSMFileExtension, files, func(sf *shardFiles, path string) {
+		sf.tsm = append(sf.tsm, path)
+	}); err != nil {
+		return st, errors.Wrap(err, "failed to walk data directory")
+	}
+	if err := walkFiles(conf, conf.WALDir, tsm1.WALFileExtension, files, func(sf *shardFiles, path string) {
+		if strings.HasPrefix(filepath.Base(path), tsm1.WALFilePrefix) {
+			sf.wal = append(sf.wal, path)
+		}
+	}); err != nil {
+		return st, errors.Wrap(err, "failed to walk WAL directory")
+	}
+	if len(files) == 0 {
+		return st, errors.New("no TSM or WAL files found")
+	}
+
+	// shards are sorted by database and retention policy, keys are in the same order
+	shards := make([]*shardFiles, 0, len(files))
+	for _, sf := range files {
+		// files of a shard are named by generation, so sorting them orders the data from the oldest to the newest
+		sort.Strings(sf.tsm)
+		sort.Strings(sf.wal)
+		shards = append(shards, sf)
+	}
+	sort.Slice(shards, func(i, j int) bool {
+		if shards[i].db != shards[j].db {
+			return shards[i].db < shards[j].db
+		}
+		return shards[i].rp < shards[j].rp
+	})
+
+	keys := make([]dbrp, len(shards))
+	for i, sf := range shards {
+		keys[i] = sf.dbrp
+	}
+	return st, writeBlocks(ctx, w, keys, func(i int, st *Stats, opts *Options) (map[string]map[int64]*Point, error) {
+		return readShardFiles(ctx, shards[i], start, end, st, opts)
+	}, start, end, &st, opts)
+}
+
+// readShardFiles groups the data in the TSM and WAL files of sf within [start, end].
+func readShardFiles(ctx context.Context, sf *shardFiles, start, end int64, st *Stats, opts *Options) (map[string]map[int64]*Point, error) {
+	entries := make(map[string]map[int64]*Point)
+	opts.Progress.setSection(SectionTSM)
+	for _, path := range sf.tsm {
+		if err := readTSMFile(ctx, path, start, end, entries, st, opts); err != nil {
+			return nil, err
+		}
+		opts.Progress.addBytes(fileSize(path))
+	}
+	opts.Progress.setSection(SectionWAL)
+	for _, path := range sf.wal {
+		if err := readWALFile(ctx, path, start, end, entries, st, opts); err != nil {
+			return nil, err
+		}
+		opts.Progress.addBytes(fileSize(path))
+	}
+	return entries, nil
+}
+
+// blockReader returns the grouped data of the i-th retention policy, recording the read lines in st.
+type blockReader func(i int, st *Stats, opts *Options) (map[string]map[int64]*Point, error)
+
+// writeBlocks writes the grouped data of retention policies keys within [start, end] read by read to w
+// in the format of influx_inspect export, or to opts.Writer, if it is set.
+// The data of a retention policy is read once the data of the previous one is written, so that only one is held
+// in memory at a time. If opts.MaxSeries is set, the data is read twice to check the limit before anything is written.
+func writeBlocks(ctx context.Context, w io.Writer, keys []dbrp, read blockReader, start, end int64, st *Stats, opts Options) (err error) {
+	c := newCollector(st, opts.Fields)
+	readSt, readOpts := st, &opts
+	if opts.DryRun || opts.MaxSeries > 0 {
+		for i := range keys {
+			entries, err := read(i, st, &opts)
+			if err != nil {
+				return err
+			}
+			c.add(entries)
+		}
+		if err := st.checkMaxSeries(opts.MaxSeries); err != nil {
+			return err
+		}
+		if opts.DryRun {
+			return nil
+		}
+		// the data is read again, without recording it in st and the progress twice
+		c = nil
+		ro := opts
+		ro.Progress = nil
+		readSt, readOpts = &Stats{}, &ro
+	}
+
+	if opts.Writer != nil {
+		w = ioutil.Discard
+		if opts.Checkpoint != nil {
+			rw, err := newResumeWriter(opts.Writer, *opts.Checkpoint, opts.Fields, opts.InputID, opts.CheckpointInterval, new(int64), opts.OnCheckpoint)
+			if err != nil {
+				return err
+			}
+			opts.Writer = rw
+		}
+	}
+	buf := bufio.NewWriter(w)
+	defer func() {
+		if ferr := buf.Flush(); ferr != nil && err == nil {
+			err = errors.Wrap(ferr, "failed to write data")
+		}
+	}()
+
+	fmt.Fprintf(buf, "# INFLUXDB EXPORT: %s - %s\n", time.Unix(0, start).UTC().Format(time.RFC3339), time.Unix(0, end).UTC().Format(time.RFC3339))
+	fmt.Fprintln(buf, "# DDL")
+	for _, k := range keys {
+		fmt.Fprintf(buf, "CREATE DATABASE %s WITH NAME %s\n", influxql.QuoteIdent(k.db), influxql.QuoteIdent(k.rp))
+	}
+	fmt.Fprintln(buf, "# DML")
+
+	e := &emitter{
+		w:         buf,
+		batch:     opts.BatchTransformer,
+		batchSize: opts.BatchSize,
+		progress:  opts.Progress,
+		dest:      opts.Writer,
+	}
+	for i, k := range keys {
+		entries, err := read(i, readSt, readOpts)
+		if err != nil {
+			return err
+		}
+		if c != nil {
+			c.add(entries)
+		}
+		fmt.Fprintf(buf, "%s%s\n", contextDatabase, k.db)
+		fmt.Fprintf(buf, "%s%s\n", contextRetentionPolicy, k.rp)
+		fmt.Fprintln(buf, startLine)
+		e.db, e.rp = k.db, k.rp
+		if err := emitEntries(ctx, e, entries, &opts); err != nil {
+			return err
+		}
+		// batches must not span retention policies
+		if err := e.flush(ctx); err != nil {
+			return err
+		}
+	}
+	if opts.Writer != nil {
+		return opts.Writer.Flush(ctx)
+	}
+	return nil
+}
+
+// fileSize returns the size of the file at path, or 0 if it cannot be determined.
+func fileSize(path string) int {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return int(fi.Size())
+}
@@ -0,0 +1,212 @@
+package taggify
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTSMFile writes a TSM file at path containing values keyed by series key and field separated by '#'.
+func writeTSMFile(t *testing.T, path string, values map[string][]tsm1.Value) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts := strings.SplitN(k, "#", 2)
+		if err := w.Write(tsm1.SeriesFieldKeyBytes(parts[0], parts[1]), values[k]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.WriteIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeWALFile writes a WAL segment at path containing entries.
+func writeWALFile(t *testing.T, path string, entries ...tsm1.WALEntry) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tsm1.NewWALSegmentWriter(f)
+	for _, e := range entries {
+		b, err := e.Encode(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(e.Type(), snappy.Encode(nil, b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransformFiles(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "taggify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+
+	writeTSMFile(t, filepath.Join(dataDir, "test", "autogen", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"test,id=foo#idd":   {tsm1.NewValue(1, "bar"), tsm1.NewValue(2, "baz")},
+		"test,id=foo#value": {tsm1.NewValue(1, 1.5), tsm1.NewValue(2, 2.5), tsm1.NewValue(3, 3.5)},
+		"test,id=foo#count": {tsm1.NewValue(1, int64(1)), tsm1.NewValue(2, int64(2))},
+		"test,id=foo#old":   {tsm1.NewValue(1, true)},
+	})
+	writeTSMFile(t, filepath.Join(dataDir, "other", "rp", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"other,id=foo#idd":   {tsm1.NewValue(5, "qux")},
+		"other,id=foo#value": {tsm1.NewValue(5, uint64(5))},
+	})
+	writeWALFile(t, filepath.Join(walDir, "test", "autogen", "1", "_00001.wal"),
+		&tsm1.WriteWALEntry{Values: map[string][]tsm1.Value{
+			string(tsm1.SeriesFieldKeyBytes("test,id=foo", "value")): {tsm1.NewValue(2, 42.0), tsm1.NewValue(int64(time.Hour), 4.5)},
+		}},
+		&tsm1.DeleteWALEntry{Keys: [][]byte{tsm1.SeriesFieldKeyBytes("test,id=foo", "old")}},
+		&tsm1.DeleteRangeWALEntry{Keys: [][]byte{tsm1.SeriesFieldKeyBytes("test,id=foo", "count")}, Min: 2, Max: 2},
+	)
+
+	out := &bytes.Buffer{}
+	st, err := TransformFiles(context.Background(), FilesConfig{
+		DataDir: dataDir,
+		WALDir:  walDir,
+	}, out, Options{
+		Fields: []string{"idd"},
+	})
+	a.NoError(err)
+	a.Equal(12, st.LinesRead)
+	a.Equal(map[string]int{"test": 3, "other": 1}, st.SeriesAfter)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	a.Equal([]string{
+		"# INFLUXDB EXPORT: 1677-09-21T00:12:43Z - 2262-04-11T23:47:16Z",
+		"# DDL",
+		"CREATE DATABASE other WITH NAME rp",
+		"CREATE DATABASE test WITH NAME autogen",
+		"# DML",
+		"# CONTEXT-DATABASE:other",
+		"# CONTEXT-RETENTION-POLICY:rp",
+		"# writing tsm data",
+		"other,id=foo,idd=qux value=5u 5",
+		"# CONTEXT-DATABASE:test",
+		"# CONTEXT-RETENTION-POLICY:autogen",
+		"# writing tsm data",
+	}, lines[:12])
+	data := append([]string(nil), lines[12:]...)
+	sort.Strings(data)
+	a.Equal([]string{
+		"test,id=foo value=3.5 3",
+		"test,id=foo value=4.5 3600000000000",
+		"test,id=foo,idd=bar count=1i 1",
+		"test,id=foo,idd=bar value=1.5 1",
+		"test,id=foo,idd=baz value=42 2",
+	}, data)
+
+	// with MaxSeries, the files are read twice, but the result is the same
+	expected := strings.Split(out.String(), "\n")
+	sort.Strings(expected)
+	out.Reset()
+	limited, err := TransformFiles(context.Background(), FilesConfig{
+		DataDir: dataDir,
+		WALDir:  walDir,
+	}, out, Options{
+		Fields:    []string{"idd"},
+		MaxSeries: 4,
+	})
+	a.NoError(err)
+	a.Equal(st, limited)
+	lines = strings.Split(out.String(), "\n")
+	sort.Strings(lines)
+	a.Equal(expected, lines)
+
+	out.Reset()
+	_, err = TransformFiles(context.Background(), FilesConfig{
+		DataDir: dataDir,
+		WALDir:  walDir,
+	}, out, Options{
+		Fields:    []string{"idd"},
+		MaxSeries: 3,
+	})
+	a.EqualError(err, "result would contain 4 series, which exceeds the limit of 3")
+	a.Empty(out.String())
+
+	out.Reset()
+	_, err = TransformFiles(context.Background(), FilesConfig{
+		DataDir:         dataDir,
+		WALDir:          walDir,
+		Database:        "test",
+		RetentionPolicy: "autogen",
+		Start:           time.Unix(0, 2),
+		End:             time.Unix(0, 3),
+	}, out, Options{
+		Fields: []string{"idd"},
+	})
+	a.NoError(err)
+	a.NotContains(out.String(), "other")
+	a.Contains(out.String(), "test,id=foo,idd=baz value=42 2\n")
+	a.Contains(out.String(), "test,id=foo value=3.5 3\n")
+	a.NotContains(out.String(), " 1\n")
+	a.NotContains(out.String(), " 3600000000000\n")
+
+	fake := newFakeInflux()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	hw, err := NewHTTPWriter(HTTPConfig{URL: srv.URL})
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	_, err = TransformFiles(context.Background(), FilesConfig{DataDir: dataDir}, nil, Options{
+		Fields: []string{"idd"},
+		Writer: hw,
+	})
+	a.NoError(err)
+	a.Equal([]string{"other,id=foo,idd=qux value=5u 5"}, fake.sorted("other/rp/ns"))
+	a.Equal([]string{
+		"test,id=foo value=3.5 3",
+		"test,id=foo,idd=bar count=1i,old=true,value=1.5 1",
+		"test,id=foo,idd=baz count=2i,value=2.5 2",
+	}, fake.sorted("test/autogen/ns"))
+
+	_, err = TransformFiles(context.Background(), FilesConfig{DataDir: dataDir, RetentionPolicy: "autogen"}, out, Options{})
+	a.Error(err)
+	_, err = TransformFiles(context.Background(), FilesConfig{DataDir: dataDir, Database: "missing"}, out, Options{})
+	a.EqualError(err, "no TSM or WAL files found")
+}
@@ -0,0 +1,359 @@
+package taggify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/pkg/errors"
+)
+
+// DefaultShardDuration is the default duration of shard groups written by TSMWriter,
+// which is the shard group duration InfluxDB uses for retention policies with infinite duration.
+const DefaultShardDuration = 7 * 24 * time.Hour
+
+// DefaultMaxBufferedValues is the default number of field values TSMWriter buffers before writing them to TSM files.
+const DefaultMaxBufferedValues = 10000000
+
+// maxTSMFileSize is the size of a TSM file, after which TSMWriter starts a new file.
+// InfluxDB does not load TSM files larger than 4GB, compactions produce files of up to 2GB.
+const maxTSMFileSize = 1 << 30
+
+// TSMConfig configures a TSMWriter.
+type TSMConfig struct {
+	// Dir is the directory to write shards to in <database>/<retention policy>/<shard ID> directories.
+	Dir string
+	// ShardDuration is the duration of shard groups, DefaultShardDuration if zero.
+	ShardDuration time.Duration
+	// ShardDurations maps 'database/retention policy' or 'database' to the duration of its shard groups,
+	// which must match the shard group duration of the retention policy in InfluxDB.
+	ShardDurations map[string]time.Duration
+	// FirstShardID is the ID of the first written shard, 1 if zero. The IDs of written shards are consecutive.
+	FirstShardID uint64
+	// MaxBufferedValues is the number of buffered field values, after which the buffered shards are written,
+	// DefaultMaxBufferedValues if zero.
+	MaxBufferedValues int
+}
+
+// Shard is a shard written by TSMWriter.
+type Shard struct {
+	Database        string
+	RetentionPolicy string
+	ID              uint64
+	// Start and End are the time range of the shard group, Start inclusive and End exclusive.
+	Start, End time.Time
+	// Dir is the directory containing the TSM files of the shard.
+	Dir string
+	// Files is the number of TSM files written.
+	Files int
+	// Values is the number of field values written.
+	Values int
+}
+
+// shardKey identifies a shard group of a retention policy.
+type shardKey struct {
+	dbrp
+	start int64
+}
+
+// tsmShard is a shard being written.
+type tsmShard struct {
+	Shard
+	// values are the buffered values keyed by series key and field.
+	values map[string]tsm1.Values
+	// generation is the generation of the last written TSM file.
+	generation int
+}
+
+// TSMWriter is a Writer, which writes lines into TSM files of shards laid out as in the data directory of InfluxDB 1.x.
+// Lines are buffered in memory and written once the database or retention policy of the written lines changes,
+// once more than MaxBufferedValues values are buffered and on Flush, each write creates new TSM files.
+// Since Transform writes the data of each retention policy at once, its shards are mostly written once.
+// The shards are not known to the meta store of InfluxDB and have no index, see Shards.
+type TSMWriter struct {
+	conf   TSMConfig
+	nextID uint64
+	shards map[shardKey]*tsmShard
+	// types are the types of fields keyed by database, retention policy, measurement and field,
+	// since InfluxDB does not allow a field to have different types within a shard.
+	types map[string]string
+	line  int
+	// last is the database and retention policy of the last written line.
+	last dbrp
+	// buffered is the number of buffered values.
+	buffered int
+}
+
+// NewTSMWriter returns a new TSMWriter configured by conf.
+func NewTSMWriter(conf TSMConfig) (*TSMWriter, error) {
+	if conf.Dir == "" {
+		return nil, errors.New("directory must be specified")
+	}
+	if conf.ShardDuration == 0 {
+		conf.ShardDuration = DefaultShardDuration
+	}
+	if conf.ShardDuration < time.Hour {
+		return nil, errors.Errorf("shard duration %s is shorter than 1h", conf.ShardDuration)
+	}
+	for k, d := range conf.ShardDurations {
+		if d < time.Hour {
+			return nil, errors.Errorf("shard duration %s of %s is shorter than 1h", d, k)
+		}
+	}
+	if conf.FirstShardID == 0 {
+		conf.FirstShardID = 1
+	}
+	if conf.MaxBufferedValues <= 0 {
+		conf.MaxBufferedValues = DefaultMaxBufferedValues
+	}
+	return &TSMWriter{
+		conf:   conf,
+		nextID: conf.FirstShardID,
+		shards: make(map[shardKey]*tsmShard),
+		types:  make(map[string]string),
+	}, nil
+}
+
+// shardDuration returns the shard group duration of retention policy rp of database db.
+func (w *TSMWriter) shardDuration(db, rp string) time.Duration {
+	if d, ok := w.conf.ShardDurations[db+"/"+rp]; ok {
+		return d
+	}
+	if d, ok := w.conf.ShardDurations[db]; ok {
+		return d
+	}
+	return w.conf.ShardDuration
+}
+
+// shard returns the shard of database db and retention policy rp containing t.
+func (w *TSMWriter) shard(db, rp string, t int64) *tsmShard {
+	d := w.shardDuration(db, rp)
+	// InfluxDB truncates the timestamp of the first point in a shard group the same way
+	start := time.Unix(0, t).Truncate(d).UTC()
+	k := shardKey{dbrp: dbrp{db: db, rp: rp}, start: start.UnixNano()}
+	if s, ok := w.shards[k]; ok {
+		return s
+	}
+	s := &tsmShard{
+		Shard: Shard{
+			Database:        db,
+			RetentionPolicy: rp,
+			Start:           start,
+			End:             start.Add(d),
+		},
+		values: make(map[string]tsm1.Values),
+	}
+	w.shards[k] = s
+	return s
+}
+
+// fieldType returns the InfluxDB type name of field value v.
+func fieldType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "float"
+	case int64:
+		return "integer"
+	case uint64:
+		return "unsigned"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// WriteLine implements Writer.
+func (w *TSMWriter) WriteLine(ctx context.Context, db, rp string, line []byte) error {
+	w.line++
+	if db == "" || rp == "" {
+		return errors.Errorf("database and retention policy of line %d must be specified, got '%s/%s'", w.line, db, rp)
+	}
+	seriesKey, p, err := parsePoint(line)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse line %d written to %s/%s", w.line, db, rp)
+	}
+	// the shards of the last retention policy are complete, unless it is written to again later
+	if k := (dbrp{db: db, rp: rp}); k != w.last {
+		if err := w.Flush(ctx); err != nil {
+			return err
+		}
+		w.last = k
+	}
+	s := w.shard(db, rp, p.Time)
+	for _, f := range p.Fields {
+		typ := fieldType(f.Value)
+		tk := db + "/" + rp + "/" + p.Measurement + "/" + f.Key
+		if prev, ok := w.types[tk]; !ok {
+			w.types[tk] = typ
+		} else if prev != typ {
+			return errors.Errorf("field type conflict: field %s of measurement %s in %s/%s is %s, already exists as %s", f.Key, p.Measurement, db, rp, typ, prev)
+		}
+		k := string(tsm1.SeriesFieldKeyBytes(seriesKey, f.Key))
+		s.values[k] = append(s.values[k], tsm1.NewValue(p.Time, f.Value))
+		s.Values++
+		w.buffered++
+	}
+	if w.buffered > w.conf.MaxBufferedValues {
+		return w.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush writes the buffered lines to new TSM files.
+// New shards are assigned consecutive IDs in order they are first written,
+// shards written at once in order of database, retention policy and time.
+func (w *TSMWriter) Flush(ctx context.Context) error {
+	if w.buffered == 0 {
+		return nil
+	}
+	for _, s := range w.sortedShards() {
+		if len(s.values) == 0 {
+			continue
+		}
+		if s.ID == 0 {
+			dir := filepath.Join(w.conf.Dir, s.Database, s.RetentionPolicy, strconv.FormatUint(w.nextID, 10))
+			if _, err := os.Stat(dir); err == nil {
+				return errors.Errorf("shard directory %s already exists", dir)
+			} else if !os.IsNotExist(err) {
+				return errors.Wrapf(err, "failed to stat %s", dir)
+			}
+			s.ID, s.Dir = w.nextID, dir
+			w.nextID++
+		}
+		if err := w.writeShard(ctx, s); err != nil {
+			return errors.Wrapf(err, "failed to write shard %d of %s/%s", s.ID, s.Database, s.RetentionPolicy)
+		}
+		s.values = make(map[string]tsm1.Values)
+	}
+	w.buffered = 0
+	return nil
+}
+
+// writeShard writes the buffered values of s to TSM files of the next generations.
+func (w *TSMWriter) writeShard(ctx context.Context, s *tsmShard) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var f *tsmFile
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if f == nil {
+			s.generation++
+			var err error
+			f, err = createTSMFile(filepath.Join(s.Dir, tsm1.DefaultFormatFileName(s.generation, 1)+"."+tsm1.TSMFileExtension))
+			if err != nil {
+				return err
+			}
+			s.Files++
+		}
+		values := s.values[k].Deduplicate()
+		for len(values) > 0 {
+			n := tsdb.DefaultMaxPointsPerBlock
+			if n > len(values) {
+				n = len(values)
+			}
+			if err := f.w.Write([]byte(k), values[:n]); err != nil {
+				f.abort()
+				return err
+			}
+			values = values[n:]
+		}
+		// all values of a key must be in a single file
+		if f.w.Size() >= maxTSMFileSize {
+			if err := f.commit(); err != nil {
+				return err
+			}
+			f = nil
+		}
+	}
+	if f != nil {
+		return f.commit()
+	}
+	return nil
+}
+
+// sortedShards returns the shards sorted by database, retention policy and time.
+func (w *TSMWriter) sortedShards() []*tsmShard {
+	shards := make([]*tsmShard, 0, len(w.shards))
+	for _, s := range w.shards {
+		shards = append(shards, s)
+	}
+	sort.Slice(shards, func(i, j int) bool {
+		if shards[i].Database != shards[j].Database {
+			return shards[i].Database < shards[j].Database
+		}
+		if shards[i].RetentionPolicy != shards[j].RetentionPolicy {
+			return shards[i].RetentionPolicy < shards[j].RetentionPolicy
+		}
+		return shards[i].Start.Before(shards[j].Start)
+	})
+	return shards
+}
+
+// Shards returns the shards written so far.
+// Before InfluxDB can use them, shard groups with matching time ranges must be created in the meta store,
+// the shard directories must be renamed to the IDs of their shards and the index must be built with influx_inspect buildtsi.
+func (w *TSMWriter) Shards() []Shard {
+	var shards []Shard
+	for _, s := range w.sortedShards() {
+		if s.Files > 0 {
+			shards = append(shards, s.Shard)
+		}
+	}
+	return shards
+}
+
+// tsmFile is a TSM file being written to a temporary file, which is renamed on commit.
+type tsmFile struct {
+	path string
+	f    *os.File
+	w    tsm1.TSMWriter
+}
+
+func createTSMFile(path string) (*tsmFile, error) {
+	f, err := os.Create(path + "." + tsm1.TmpTSMFileExtension)
+	if err != nil {
+		return nil, err
+	}
+	w, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &tsmFile{path: path, f: f, w: w}, nil
+}
+
+func (f *tsmFile) commit() error {
+	if err := f.w.WriteIndex(); err != nil {
+		f.abort()
+		return errors.Wrapf(err, "failed to write index of %s", f.path)
+	}
+	// Close syncs and closes the file
+	if err := f.w.Close(); err != nil {
+		os.Remove(f.f.Name())
+		return errors.Wrapf(err, "failed to write %s", f.path)
+	}
+	return os.Rename(f.f.Name(), f.path)
+}
+
+func (f *tsmFile) abort() {
+	f.w.Close()
+	os.Remove(f.f.Name())
+}
@@ -0,0 +1,168 @@
+package taggify
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/stretchr/testify/assert"
+)
+
+// readTSMDir returns the values in all TSM files in dir keyed by series key and field separated by '#'.
+func readTSMDir(t *testing.T, dir string) map[string][]interface{} {
+	paths, err := filepath.Glob(filepath.Join(dir, "*."+tsm1.TSMFileExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string][]interface{})
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := tsm1.NewTSMReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < r.KeyCount(); i++ {
+			key, _ := r.KeyAt(i)
+			vs, err := r.ReadAll(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			series, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+			k := string(series) + "#" + string(field)
+			for _, v := range vs {
+				values[k] = append(values[k], v.UnixNano(), v.Value())
+			}
+		}
+		r.Close()
+	}
+	return values
+}
+
+func TestTSMWriter(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "taggify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	day := int64(24 * time.Hour)
+	data := strings.Join([]string{
+		`test,id=foo idd="bar" 1`,
+		`test,id=foo value=1.5,count=1i 1`,
+		`test,id=foo value=2.5 2`,
+		`test,id=foo idd="baz" 2`,
+		`test,id=foo value=3.5 ` + strconv.FormatInt(8*day, 10),
+	}, "\n")
+	wal := strings.Join([]string{
+		`test,id=foo value=4.5 2`,
+		`# CONTEXT-DATABASE:other`,
+		`# CONTEXT-RETENTION-POLICY:rp`,
+		`other flag=true ` + strconv.FormatInt(2*day, 10),
+	}, "\n")
+	input := strings.Join([]string{header, data, footer, wal}, "\n")
+
+	w, err := NewTSMWriter(TSMConfig{
+		Dir:            dir,
+		ShardDurations: map[string]time.Duration{"other": 24 * time.Hour},
+		FirstShardID:   10,
+	})
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+	_, err = Transform(context.Background(), strings.NewReader(input), nil, Options{
+		Fields: []string{"idd"},
+		Writer: w,
+	})
+	a.NoError(err)
+
+	// the shards of test/autogen are written once the WAL section switches to other/rp
+	shards := w.Shards()
+	if a.Len(shards, 3) {
+		a.Equal(Shard{
+			Database:        "other",
+			RetentionPolicy: "rp",
+			ID:              12,
+			Start:           time.Unix(0, 2*day).UTC(),
+			End:             time.Unix(0, 3*day).UTC(),
+			Dir:             filepath.Join(dir, "other", "rp", "12"),
+			Files:           1,
+			Values:          1,
+		}, shards[0])
+		a.Equal(Shard{
+			Database:        "test",
+			RetentionPolicy: "autogen",
+			ID:              10,
+			// shard groups are aligned as in InfluxDB, which is not on the Unix epoch
+			Start:  time.Unix(0, 0).Truncate(DefaultShardDuration).UTC(),
+			End:    time.Unix(0, 0).Truncate(DefaultShardDuration).Add(DefaultShardDuration).UTC(),
+			Dir:    filepath.Join(dir, "test", "autogen", "10"),
+			Files:  1,
+			Values: 4,
+		}, shards[1])
+		a.Equal(uint64(11), shards[2].ID)
+		a.Equal(1, shards[2].Values)
+	}
+
+	a.Equal(map[string][]interface{}{
+		"test,id=foo,idd=bar#count": {int64(1), int64(1)},
+		"test,id=foo,idd=bar#value": {int64(1), 1.5},
+		"test,id=foo,idd=baz#value": {int64(2), 2.5},
+		// the WAL section is not grouped, the later write wins
+		"test,id=foo#value": {int64(2), 4.5},
+	}, readTSMDir(t, filepath.Join(dir, "test", "autogen", "10")))
+	a.Equal(map[string][]interface{}{
+		"test,id=foo#value": {8 * day, 3.5},
+	}, readTSMDir(t, filepath.Join(dir, "test", "autogen", "11")))
+	a.Equal(map[string][]interface{}{
+		"other#flag": {2 * day, true},
+	}, readTSMDir(t, filepath.Join(dir, "other", "rp", "12")))
+
+	// written shards are never overwritten
+	w, err = NewTSMWriter(TSMConfig{Dir: dir, FirstShardID: 11})
+	a.NoError(err)
+	a.NoError(w.WriteLine(context.Background(), "test", "autogen", []byte("test value=1 1")))
+	a.EqualError(w.Flush(context.Background()),
+		"shard directory "+filepath.Join(dir, "test", "autogen", "11")+" already exists")
+
+	w, err = NewTSMWriter(TSMConfig{Dir: dir, FirstShardID: 20})
+	a.NoError(err)
+	a.NoError(w.WriteLine(context.Background(), "test", "autogen", []byte("test value=1 1")))
+	a.EqualError(w.WriteLine(context.Background(), "test", "autogen", []byte(`test value="1" 2`)),
+		"field type conflict: field value of measurement test in test/autogen is string, already exists as float")
+	a.EqualError(w.WriteLine(context.Background(), "test", "", []byte("test value=1 1")),
+		"database and retention policy of line 3 must be specified, got 'test/'")
+
+	// buffered values are written once there are more than MaxBufferedValues
+	w, err = NewTSMWriter(TSMConfig{Dir: dir, FirstShardID: 30, MaxBufferedValues: 2})
+	a.NoError(err)
+	for _, line := range []string{"test a=1,b=2 1", "test a=3 2", "test a=4 3"} {
+		a.NoError(w.WriteLine(context.Background(), "buffered", "autogen", []byte(line)))
+	}
+	if shards := w.Shards(); a.Len(shards, 1) {
+		a.Equal(1, shards[0].Files)
+		a.Equal(4, shards[0].Values)
+	}
+	a.NoError(w.Flush(context.Background()))
+	if shards := w.Shards(); a.Len(shards, 1) {
+		a.Equal(2, shards[0].Files)
+		a.Equal(4, shards[0].Values)
+	}
+	a.Equal(map[string][]interface{}{
+		"test#a": {int64(1), 1.0, int64(2), 3.0, int64(3), 4.0},
+		"test#b": {int64(1), 2.0},
+	}, readTSMDir(t, filepath.Join(dir, "buffered", "autogen", "30")))
+
+	_, err = NewTSMWriter(TSMConfig{Dir: dir, ShardDuration: time.Minute})
+	a.Error(err)
+}
@@ -0,0 +1,310 @@
+package taggify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxExamples is the default number of examples of each kind of discrepancy reported by Verify.
+const DefaultMaxExamples = 10
+
+// VerifyConfig configures Verify. It must describe the conversion, which produced the converted data.
+type VerifyConfig struct {
+	// Fields are the names of the fields converted to tags.
+	Fields []string
+	// Transformers are the transformers applied to each grouped row.
+	Transformers []PointTransformer
+	// MaxExamples is the number of examples reported for each kind of discrepancy, DefaultMaxExamples if zero.
+	MaxExamples int
+}
+
+// Discrepancy is a field value, which is missing, extra or altered in the converted data.
+type Discrepancy struct {
+	Database        string `json:"database"`
+	RetentionPolicy string `json:"retention_policy"`
+	// SeriesKey is the series key the value is expected at, or found at if it is extra.
+	SeriesKey string `json:"series_key"`
+	Time      int64  `json:"time"`
+	Field     string `json:"field"`
+	// Expected is the expected value in line protocol representation, empty if the value is extra.
+	Expected string `json:"expected,omitempty"`
+	// Actual is the value found in the converted data in line protocol representation, empty if the value is missing.
+	Actual string `json:"actual,omitempty"`
+}
+
+func (d Discrepancy) String() string {
+	var b strings.Builder
+	if d.Database != "" {
+		fmt.Fprintf(&b, "%s/%s: ", d.Database, d.RetentionPolicy)
+	}
+	v := d.Expected
+	if v == "" {
+		v = d.Actual
+	}
+	fmt.Fprintf(&b, "%s %s=%s %d", d.SeriesKey, d.Field, v, d.Time)
+	if d.Expected != "" && d.Actual != "" {
+		fmt.Fprintf(&b, " (found %s)", d.Actual)
+	}
+	return b.String()
+}
+
+// Verification is the result of Verify.
+type Verification struct {
+	// Expected is the number of field values expected in the converted data, Matched is the number of them found unchanged.
+	Expected int64 `json:"expected"`
+	Matched  int64 `json:"matched"`
+	// Missing is the number of expected field values not found in the converted data.
+	Missing int64 `json:"missing"`
+	// Extra is the number of field values in the converted data, which are not expected or found more than once.
+	Extra int64 `json:"extra"`
+	// Altered is the number of expected field values found with a different value or type.
+	Altered int64 `json:"altered"`
+
+	MissingExamples []Discrepancy `json:"missing_examples,omitempty"`
+	ExtraExamples   []Discrepancy `json:"extra_examples,omitempty"`
+	AlteredExamples []Discrepancy `json:"altered_examples,omitempty"`
+}
+
+// OK reports whether the converted data contains every expected field value exactly once and nothing else.
+func (v *Verification) OK() bool {
+	return v.Missing == 0 && v.Extra == 0 && v.Altered == 0
+}
+
+// valueKey identifies a field value of a point.
+type valueKey struct {
+	dbrp
+	series string
+	time   int64
+	field  string
+}
+
+func (k valueKey) discrepancy(expected, actual string) Discrepancy {
+	return Discrepancy{
+		Database:        k.db,
+		RetentionPolicy: k.rp,
+		SeriesKey:       k.series,
+		Time:            k.time,
+		Field:           k.field,
+		Expected:        expected,
+		Actual:          actual,
+	}
+}
+
+// expectedValue is a field value expected in the converted data want more times.
+type expectedValue struct {
+	value string
+	want  int
+}
+
+// verifier checks the converted data against the field values expected from the original.
+type verifier struct {
+	max      int
+	expected map[valueKey]expectedValue
+	res      *Verification
+}
+
+func (v *verifier) example(examples *[]Discrepancy, d Discrepancy) {
+	if len(*examples) < v.max {
+		*examples = append(*examples, d)
+	}
+}
+
+// missingExample records d as an example of a missing value, if it is among the smallest v.max according to lessDiscrepancy.
+// Missing values are found in map order, so this keeps the examples the same on each run.
+func (v *verifier) missingExample(d Discrepancy) {
+	ds := v.res.MissingExamples
+	i := sort.Search(len(ds), func(i int) bool { return lessDiscrepancy(&d, &ds[i]) })
+	if i == v.max {
+		return
+	}
+	if len(ds) < v.max {
+		ds = append(ds, Discrepancy{})
+	}
+	copy(ds[i+1:], ds[i:])
+	ds[i] = d
+	v.res.MissingExamples = ds
+}
+
+// expect records the fields of p as expected in the converted data.
+// If the same field value is expected more than once, the last value is expected, as InfluxDB keeps it.
+func (v *verifier) expect(db, rp string, p *Point) {
+	series := p.SeriesKey()
+	for _, f := range p.Fields {
+		k := valueKey{dbrp: dbrp{db: db, rp: rp}, series: series, time: p.Time, field: f.Key}
+		e := v.expected[k]
+		e.value = string(appendValue(nil, f.Value))
+		e.want++
+		v.expected[k] = e
+		v.res.Expected++
+	}
+}
+
+// check checks the fields of p found in the converted data.
+func (v *verifier) check(db, rp string, p *Point) {
+	series := p.SeriesKey()
+	for _, f := range p.Fields {
+		k := valueKey{dbrp: dbrp{db: db, rp: rp}, series: series, time: p.Time, field: f.Key}
+		actual := string(appendValue(nil, f.Value))
+		e, ok := v.expected[k]
+		if !ok {
+			v.res.Extra++
+			v.example(&v.res.ExtraExamples, k.discrepancy("", actual))
+			continue
+		}
+		if e.value == actual {
+			v.res.Matched++
+		} else {
+			v.res.Altered++
+			v.example(&v.res.AlteredExamples, k.discrepancy(e.value, actual))
+		}
+		if e.want--; e.want == 0 {
+			delete(v.expected, k)
+		} else {
+			v.expected[k] = e
+		}
+	}
+}
+
+// Verify checks that the converted data contains every field value of the original data exactly once,
+// under the series key expected from the conversion described by conf, and nothing else.
+// Both original and converted are exports produced by influx_inspect or plain line protocol.
+// The data section of the original is grouped and converted as by Transform, the lines following it are expected unchanged.
+// Rows, which contain only converted fields, cannot be written, so their values are reported as missing.
+// Memory usage is proportional to the size of the original data, as with Transform.
+func Verify(ctx context.Context, original, converted io.Reader, conf VerifyConfig) (*Verification, error) {
+	v := &verifier{
+		max:      conf.MaxExamples,
+		expected: make(map[valueKey]expectedValue),
+		res:      &Verification{},
+	}
+	if v.max <= 0 {
+		v.max = DefaultMaxExamples
+	}
+
+	// rows of the data section are grouped by database and retention policy before conversion
+	entries := make(map[dbrp]map[string]map[int64]*Point)
+	if err := readExportSections(ctx, original, func(section Section, db, rp string, p *Point) error {
+		if section == SectionWAL {
+			v.expect(db, rp, p)
+			return nil
+		}
+		k := dbrp{db: db, rp: rp}
+		rows, ok := entries[k]
+		if !ok {
+			rows = make(map[string]map[int64]*Point)
+			entries[k] = rows
+		}
+		group(rows, p.SeriesKey(), p, nil)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to read original data")
+	}
+
+	for k, rows := range entries {
+		for _, rows := range rows {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			for _, p := range rows {
+				if err := v.expectRow(k, p, conf); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if err := readExport(ctx, converted, func(db, rp string, p *Point) error {
+		v.check(db, rp, p)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to read converted data")
+	}
+
+	for k, e := range v.expected {
+		v.res.Missing += int64(e.want)
+		v.missingExample(k.discrepancy(e.value, ""))
+	}
+	return v.res, nil
+}
+
+// expectRow converts grouped row p of retention policy k as Transform does and records the result as expected.
+func (v *verifier) expectRow(k dbrp, p *Point, conf VerifyConfig) error {
+	series := p.SeriesKey()
+	promoted := 0
+	for _, name := range conf.Fields {
+		if _, ok := p.Field(name); ok {
+			promoted++
+		}
+	}
+	if promoted > 0 && promoted == len(p.Fields) {
+		for _, f := range p.Fields {
+			vk := valueKey{dbrp: k, series: series, time: p.Time, field: f.Key}
+			v.res.Expected++
+			v.res.Missing++
+			v.missingExample(vk.discrepancy(string(appendValue(nil, f.Value)), ""))
+		}
+		return nil
+	}
+	promote(p, conf.Fields)
+	points, err := applyTransformers(conf.Transformers, p)
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		v.expect(k.db, k.rp, p)
+	}
+	return nil
+}
+
+// lessDiscrepancy orders discrepancies by database, retention policy, series key, time and field.
+func lessDiscrepancy(a, b *Discrepancy) bool {
+	switch {
+	case a.Database != b.Database:
+		return a.Database < b.Database
+	case a.RetentionPolicy != b.RetentionPolicy:
+		return a.RetentionPolicy < b.RetentionPolicy
+	case a.SeriesKey != b.SeriesKey:
+		return a.SeriesKey < b.SeriesKey
+	case a.Time != b.Time:
+		return a.Time < b.Time
+	}
+	return a.Field < b.Field
+}
+
+// WriteVerification writes v to w in specified format, which is either "text" or "json".
+func WriteVerification(w io.Writer, v *Verification, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(v)
+	case "text", "":
+	default:
+		return errors.Errorf("unknown verification format '%s'", format)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Expected field values: %d, matched: %d\n", v.Expected, v.Matched)
+	for _, s := range []struct {
+		name     string
+		n        int64
+		examples []Discrepancy
+	}{
+		{"Missing", v.Missing, v.MissingExamples},
+		{"Extra", v.Extra, v.ExtraExamples},
+		{"Altered", v.Altered, v.AlteredExamples},
+	} {
+		fmt.Fprintf(&b, "%s: %d\n", s.name, s.n)
+		for _, d := range s.examples {
+			fmt.Fprintf(&b, "\t%s\n", d)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,100 @@
+package taggify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	a := assert.New(t)
+
+	data := `test,id=foo idd="bar" 1511629912071663075
+test,id=foo int=42i 1511629912071663075
+test,id=bar idd="baz",value=1.5 1511629912071663076
+test,id=baz idd="qux" 1511629912071663077`
+	wal := `test,id=qux idd="bar" 1511629912071663078`
+	original := strings.Join([]string{header, data, footer, wal}, "\n")
+
+	converted := &bytes.Buffer{}
+	_, err := Transform(context.Background(), strings.NewReader(original), converted, Options{Fields: []string{"idd"}})
+	if !a.NoError(err) {
+		t.FailNow()
+	}
+
+	conf := VerifyConfig{Fields: []string{"idd"}}
+	v, err := Verify(context.Background(), strings.NewReader(original), bytes.NewReader(converted.Bytes()), conf)
+	a.NoError(err)
+	a.Equal(&Verification{
+		Expected: 4,
+		Matched:  3,
+		Missing:  1,
+		MissingExamples: []Discrepancy{
+			{Database: "test", RetentionPolicy: "autogen", SeriesKey: "test,id=baz", Time: 1511629912071663077, Field: "idd", Expected: `"qux"`},
+		},
+	}, v)
+	a.False(v.OK())
+
+	tampered := strings.NewReplacer(
+		"test,id=foo,idd=bar int=42i", "test,id=foo,idd=bar int=43i",
+		"test,id=bar,idd=baz value=1.5 1511629912071663076", "test,id=bar,idd=baz value=1.5 1511629912071663076\ntest,id=bar,idd=baz value=1.5 1511629912071663076\ntest,id=bar value=1.5 1511629912071663076",
+		`test,id=qux idd="bar" 1511629912071663078`, "",
+	).Replace(converted.String())
+	v, err = Verify(context.Background(), strings.NewReader(original), strings.NewReader(tampered), conf)
+	a.NoError(err)
+	a.Equal(int64(1), v.Matched)
+	a.Equal(int64(2), v.Missing)
+	a.Equal(int64(2), v.Extra)
+	a.Equal(int64(1), v.Altered)
+	a.Equal([]Discrepancy{
+		{Database: "test", RetentionPolicy: "autogen", SeriesKey: "test,id=bar,idd=baz", Time: 1511629912071663076, Field: "value", Actual: "1.5"},
+		{Database: "test", RetentionPolicy: "autogen", SeriesKey: "test,id=bar", Time: 1511629912071663076, Field: "value", Actual: "1.5"},
+	}, v.ExtraExamples)
+
+	out := &bytes.Buffer{}
+	a.NoError(WriteVerification(out, v, "text"))
+	a.Equal(`Expected field values: 4, matched: 1
+Missing: 2
+	test/autogen: test,id=baz idd="qux" 1511629912071663077
+	test/autogen: test,id=qux idd="bar" 1511629912071663078
+Extra: 2
+	test/autogen: test,id=bar,idd=baz value=1.5 1511629912071663076
+	test/autogen: test,id=bar value=1.5 1511629912071663076
+Altered: 1
+	test/autogen: test,id=foo,idd=bar int=42i 1511629912071663075 (found 43i)
+`, out.String())
+
+	out.Reset()
+	a.NoError(WriteVerification(out, v, "json"))
+	var decoded Verification
+	a.NoError(json.Unmarshal(out.Bytes(), &decoded))
+	a.Equal(v, &decoded)
+	a.Error(WriteVerification(out, v, "yaml"))
+
+	v, err = Verify(context.Background(), strings.NewReader("test idd=\"bar\",value=1 1\n"), strings.NewReader("test,idd=bar value=1 1\n"), conf)
+	a.NoError(err)
+	a.True(v.OK())
+	a.Equal(int64(1), v.Matched)
+
+	var many []string
+	for i := 0; i < 50; i++ {
+		many = append(many, fmt.Sprintf("test,id=%02d value=1 1", i))
+	}
+	for i := 0; i < 10; i++ {
+		v, err = Verify(context.Background(), strings.NewReader(strings.Join(many, "\n")), strings.NewReader(""), VerifyConfig{MaxExamples: 2})
+		a.NoError(err)
+		a.Equal(int64(50), v.Missing)
+		a.Equal([]Discrepancy{
+			{SeriesKey: "test,id=00", Time: 1, Field: "value", Expected: "1"},
+			{SeriesKey: "test,id=01", Time: 1, Field: "value", Expected: "1"},
+		}, v.MissingExamples)
+	}
+
+	_, err = Verify(context.Background(), strings.NewReader(original), strings.NewReader("test value=\n"), conf)
+	a.Error(err)
+}